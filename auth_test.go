@@ -1,11 +1,7 @@
 package main
 
 import (
-  "bytes"
-  "encoding/json"
   "fmt"
-  "io/ioutil"
-  "micrified.com/route"
   "micrified.com/route/login"
   "micrified.com/route/logout"
   "micrified.com/service/auth"
@@ -16,58 +12,6 @@ import (
   "time"
 )
 
-const (
-  Hostname    = "http://localhost:3070"
-  LoginRoute  = "/login"
-  LogoutRoute = "/logout"
-)
-
-
-/*\
- *******************************************************************************
- *                              Generic Functions                              *
- *******************************************************************************
-\*/
-
-
-// Request is a generic function template 
-func Request [T any, U any] (url, method string, status int, u U, t *T) error {
-  var (
-    body   []byte         = []byte{}
-    buffer bytes.Buffer   = bytes.Buffer{}
-    req    *http.Request  = nil
-    res    *http.Response = nil
-    err    error          = nil
-  )
-
-  // Marshal and send request
-  if err = json.NewEncoder(&buffer).Encode(u); nil != err {
-    return err
-  }
-  if req, err = http.NewRequest(method, url, bytes.NewBuffer(buffer.Bytes())); nil != err {
-    return err
-  }
-  req.Header.Set(route.ContentTypeName, route.ContentTypeJSON)
-
-  // Get response and unmarshal if required
-  if res, err = http.DefaultClient.Do(req); nil != err {
-    return err
-  }
-  if status != res.StatusCode {
-    return fmt.Errorf("Bad status (got %d, expected %d)", res.StatusCode, status)
-  }
-  if nil != t {
-    if body, err = ioutil.ReadAll(res.Body); nil != err {
-      return err
-    }
-    if err = json.Unmarshal(body, t); nil != err {
-      return err
-    }
-  }
-  return nil
-}
-
-
 /*\
  *******************************************************************************
  *                                    Tests                                    *
@@ -212,3 +156,36 @@ func TestSessionExpiration (t *testing.T) {
   }
 }
 
+
+// TestBearerSession tests that a session token supplied in the Authorization
+// header authorizes a request without any credentials in the body, and that
+// the token is no longer accepted once the session has ended
+func TestBearerSession (t *testing.T) {
+  loginFunc, logoutFunc := Request[login.SessionCredential, login.LoginCredential],
+                           BearerRequest[any, any]
+  validCredentials := login.LoginCredential {
+    Username: os.Getenv("TEST_USERNAME"), Passphrase: os.Getenv("TEST_PASSPHRASE"),
+  }
+
+  // Login
+  sessionCredential := login.SessionCredential{}
+  err := loginFunc(LoginURL, http.MethodPost, http.StatusOK, validCredentials,
+    &sessionCredential)
+  if nil != err {
+    t.Fatalf("Login request error: %v", err)
+  }
+
+  // Logout with the bearer token (and no body)
+  err = logoutFunc(LogoutURL, http.MethodPost, sessionCredential.Secret,
+    http.StatusNoContent, nil, nil)
+  if nil != err {
+    t.Fatalf("Bearer logout request error: %v", err)
+  }
+
+  // The token must no longer be accepted
+  err = logoutFunc(LogoutURL, http.MethodPost, sessionCredential.Secret,
+    http.StatusUnauthorized, nil, nil)
+  if nil != err {
+    t.Fatalf("Bearer token valid after logout: %v", err)
+  }
+}
@@ -1,11 +1,9 @@
 package main
 
 import (
-  "bytes"
   "encoding/json"
   "fmt"
   "io/ioutil"
-  "micrified.com/route"
   "micrified.com/route/blog"
   "micrified.com/route/login"
   "micrified.com/route/logout"
@@ -15,59 +13,6 @@ import (
   "testing"
 )
 
-const (
-  Hostname    = "http://localhost:3070"
-  BlogRoute   = "/blog"
-  LoginRoute  = "/login"
-  LogoutRoute = "/logout"
-)
-
-
-/*\
- *******************************************************************************
- *                              Generic Functions                              *
- *******************************************************************************
-\*/
-
-
-// Request is a generic function template 
-func Request [T any, U any] (url, method string, status int, u U, t *T) error {
-  var (
-    body   []byte         = []byte{}
-    buffer bytes.Buffer   = bytes.Buffer{}
-    req    *http.Request  = nil
-    res    *http.Response = nil
-    err    error          = nil
-  )
-
-  // Marshal and send request
-  if err = json.NewEncoder(&buffer).Encode(u); nil != err {
-    return err
-  }
-  if req, err = http.NewRequest(method, url, bytes.NewBuffer(buffer.Bytes())); nil != err {
-    return err
-  }
-  req.Header.Set(route.ContentTypeName, route.ContentTypeJSON)
-
-  // Get response and unmarshal if required
-  if res, err = http.DefaultClient.Do(req); nil != err {
-    return err
-  }
-  if status != res.StatusCode {
-    return fmt.Errorf("Bad status (got %d, expected %d)", res.StatusCode, status)
-  }
-  if nil != t {
-    if body, err = ioutil.ReadAll(res.Body); nil != err {
-      return err
-    }
-    if err = json.Unmarshal(body, t); nil != err {
-      return err
-    }
-  }
-  return nil
-}
-
-
 /*\
  *******************************************************************************
  *                                    Tests                                    *
@@ -3,16 +3,37 @@ package main
 import (
   "encoding/json"
   "fmt"
+  "micrified.com/service/access"
   "micrified.com/service/auth"
+  "micrified.com/service/certificate"
   "micrified.com/service/database"
+  "micrified.com/service/notify"
+  "micrified.com/service/webauthn"
   "os"
+  "time"
 )
 
 type Config struct {
+  Access       access.Config
   Auth         auth.Config
   Database     database.Config
+  WebAuthn     webauthn.Config
+  Notify       notify.Config
+  TLS          certificate.Config
+  Proxies      []string
+  ProxyHeader  string
   Host         string
   Port         string
+  Drain        int
+}
+
+// DrainTimeout returns how long to wait for requests in flight to finish
+// when shutting down. It defaults to 30 seconds
+func (c *Config) DrainTimeout () time.Duration {
+  if c.Drain > 0 {
+    return time.Duration(c.Drain) * time.Second
+  }
+  return 30 * time.Second
 }
 
 func (c *Config) Read (filepath string) error {
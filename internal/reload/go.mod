@@ -0,0 +1,3 @@
+module micrified.com/internal/reload
+
+go 1.22.3
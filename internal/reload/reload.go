@@ -0,0 +1,32 @@
+// Package reload watches files that services read once and reload whenever
+// they change (e.g. access rules, certificates)
+
+package reload
+
+import (
+  "log"
+  "time"
+)
+
+// Watch calls reload at every interval until stop is closed, in a goroutine
+// of its own. Errors are logged under the name (the files last read remain
+// in use), as are reloads of the file reported
+func Watch (name, file string, interval time.Duration, stop <-chan struct{},
+  reload func () (bool, error)) {
+  go func () {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+      select {
+      case <-stop:
+        return
+      case <-ticker.C:
+        if ok, err := reload(); nil != err {
+          log.Printf("%s: %v\n", name, err)
+        } else if ok {
+          log.Printf("%s: Reloaded %s\n", name, file)
+        }
+      }
+    }
+  }()
+}
@@ -1,3 +1,11 @@
 module micrified.com/internal/user
 
+replace micrified.com/internal/reload => ../reload
+
+replace micrified.com/service/access => ../../service/access
+
 go 1.22.3
+
+require micrified.com/service/access v0.0.0-00010101000000-000000000000
+
+require micrified.com/internal/reload v0.0.0-00010101000000-000000000000 // indirect
@@ -3,22 +3,191 @@ package user
 import (
   "net"
   "net/http"
+  "net/netip"
   "fmt"
   "context"
+  "micrified.com/service/access"
+  "slices"
+  "strings"
 )
 
 const (
-  UserIPKey = 0
+  UserIPKey          = 0
+  UserNameKey        = 1
+  UserPermissionsKey = 2
+  UserAgentKey       = 3
+  RequestIDKey       = 4
 )
 
-func RequestIP(r *http.Request) (string, error) {
-  ip, _, err := net.SplitHostPort(r.RemoteAddr)
+const (
+  AuthorizationName = "Authorization"
+  BearerScheme      = "Bearer"
+  ForwardedName     = "Forwarded"
+  ForwardedForName  = "X-Forwarded-For"
+)
+
+// Proxies: The ranges of reverse proxies trusted to report the client IP, and
+// the one header they report it in (Forwarded or X-Forwarded-For)
+type Proxies struct {
+  Ranges []netip.Prefix
+  Header string
+}
+
+// NewProxies parses the trusted ranges, given in CIDR notation or as single
+// IPs. If any are given, the header must name Forwarded or X-Forwarded-For
+func NewProxies(ranges []string, header string) (Proxies, error) {
+  prefixes, err := access.ParsePrefixes(ranges)
+  if nil != err {
+    return Proxies{}, fmt.Errorf("Bad trusted proxy: %w", err)
+  }
+  header = http.CanonicalHeaderKey(header)
+  if 0 != len(prefixes) && ForwardedName != header &&
+    ForwardedForName != header {
+    return Proxies{}, fmt.Errorf("Unmet condition: ProxyHeader is %s or %s",
+      ForwardedName, ForwardedForName)
+  }
+  return Proxies { Ranges: prefixes, Header: header }, nil
+}
+
+// Trusted returns true if the address belongs to a trusted proxy
+func (p *Proxies) Trusted(addr netip.Addr) bool {
+  return slices.ContainsFunc(p.Ranges, func (prefix netip.Prefix) bool {
+    return prefix.Contains(addr)
+  })
+}
+
+// RequestIP returns the IP of the client. If the immediate peer is a trusted
+// proxy, the addresses it reports (in the configured header only) are
+// followed from the nearest hop outwards, until one that is not a trusted
+// proxy is found. Reports from untrusted peers are ignored, since they may
+// be forged
+func RequestIP(r *http.Request, trusted Proxies) (string, error) {
+  host, _, err := net.SplitHostPort(r.RemoteAddr)
   if nil != err {
-    return "", fmt.Errorf("Host %q is not of form 'host:port' or similar")
+    return "", fmt.Errorf("Host %q is not of form 'host:port' or similar",
+      r.RemoteAddr)
+  }
+  addr, err := netip.ParseAddr(host)
+  if nil != err || 0 == len(trusted.Ranges) {
+    return host, nil
+  }
+  addr = addr.Unmap()
+
+  // Follow the chain of hops while they are trusted
+  chain := forwarded(r, trusted.Header)
+  for i := len(chain) - 1; i >= 0 && trusted.Trusted(addr); i-- {
+    next, ok := parseNode(chain[i])
+    if !ok {
+      break
+    }
+    addr = next
+  }
+  return addr.String(), nil
+}
+
+// forwarded returns the addresses of the hops reported by proxies in the
+// header (Forwarded, as per RFC 7239, or X-Forwarded-For), from the client
+// outwards. Other headers are ignored
+func forwarded(r *http.Request, header string) []string {
+  var chain []string = []string{}
+  switch values := r.Header.Values(header); header {
+  case ForwardedName:
+    if 0 == len(values) {
+      return chain
+    }
+    for _, element := range strings.Split(strings.Join(values, ","), ",") {
+      node := ""
+      for _, pair := range strings.Split(element, ";") {
+        key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+        if ok && strings.EqualFold("for", key) {
+          node = value
+        }
+      }
+      chain = append(chain, node)
+    }
+  case ForwardedForName:
+    for _, value := range values {
+      chain = append(chain, strings.Split(value, ",")...)
+    }
+  }
+  return chain
+}
+
+// parseNode parses a reported hop: an IP, possibly quoted, bracketed (IPv6)
+// and followed by a port. Obfuscated or unknown hops are not parsed
+func parseNode(node string) (netip.Addr, bool) {
+  node = strings.Trim(strings.TrimSpace(node), "\"")
+  if addrPort, err := netip.ParseAddrPort(node); nil == err {
+    return addrPort.Addr().Unmap(), true
+  }
+  node = strings.TrimSuffix(strings.TrimPrefix(node, "["), "]")
+  if addr, err := netip.ParseAddr(node); nil == err {
+    return addr.Unmap(), true
   }
-  return ip, nil
+  return netip.Addr{}, false
+}
+
+// RequestToken returns the bearer token supplied with the request. The
+// Authorization header takes precedence over the named cookie. The cookie
+// is ignored if no name is given
+func RequestToken(r *http.Request, cookie string) (string, bool) {
+  if value := r.Header.Get(AuthorizationName); "" != value {
+    scheme, token, ok := strings.Cut(value, " ")
+    if !ok || !strings.EqualFold(scheme, BearerScheme) {
+      return "", false
+    }
+    token = strings.TrimSpace(token)
+    return token, "" != token
+  }
+  if "" == cookie {
+    return "", false
+  }
+  if c, err := r.Cookie(cookie); nil == err && "" != c.Value {
+    return c.Value, true
+  }
+  return "", false
 }
 
 func ContextWithIP(c context.Context, ip string) context.Context {
   return context.WithValue(c, UserIPKey, ip)
 }
+
+func ContextWithAgent(c context.Context, agent string) context.Context {
+  return context.WithValue(c, UserAgentKey, agent)
+}
+
+// Agent returns the user agent attached to the context, if any
+func Agent(c context.Context) string {
+  agent, _ := c.Value(UserAgentKey).(string)
+  return agent
+}
+
+func ContextWithName(c context.Context, name string) context.Context {
+  return context.WithValue(c, UserNameKey, name)
+}
+
+// Name returns the authorized username attached to the context, if any
+func Name(c context.Context) (string, bool) {
+  name, ok := c.Value(UserNameKey).(string)
+  return name, ok
+}
+
+func ContextWithPermissions(c context.Context, permissions []string) context.Context {
+  return context.WithValue(c, UserPermissionsKey, permissions)
+}
+
+// Permitted returns true if the permission was granted to the authorized user
+func Permitted(c context.Context, permission string) bool {
+  permissions, _ := c.Value(UserPermissionsKey).([]string)
+  return slices.Contains(permissions, permission)
+}
+
+func ContextWithRequestID(c context.Context, id string) context.Context {
+  return context.WithValue(c, RequestIDKey, id)
+}
+
+// RequestID returns the identifier of the request attached to the context
+func RequestID(c context.Context) string {
+  id, _ := c.Value(RequestIDKey).(string)
+  return id
+}
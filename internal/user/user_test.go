@@ -0,0 +1,199 @@
+package user
+
+import (
+  "net/http"
+  "net/netip"
+  "slices"
+  "testing"
+)
+
+const (
+  TestPeer  = "10.0.0.1"
+  TestProxy = "10.0.0.2"
+)
+
+
+/*\
+ *******************************************************************************
+ *                                   Helpers                                   *
+ *******************************************************************************
+\*/
+
+
+func newTestProxies (t *testing.T, header string) Proxies {
+  proxies, err := NewProxies([]string{ "10.0.0.0/24", "::1" }, header)
+  if nil != err {
+    t.Fatalf("NewProxies failed: %v", err)
+  }
+  return proxies
+}
+
+// newRequest returns a request from the peer, with the headers set
+func newRequest (peer string, headers map[string][]string) *http.Request {
+  r, _ := http.NewRequest(http.MethodGet, "/", nil)
+  r.RemoteAddr = peer
+  for name, values := range headers {
+    for _, value := range values {
+      r.Header.Add(name, value)
+    }
+  }
+  return r
+}
+
+
+/*\
+ *******************************************************************************
+ *                                    Tests                                    *
+ *******************************************************************************
+\*/
+
+
+// TestNewProxies verifies that ranges and single IPs are parsed, and that a
+// header must be chosen if any proxy is trusted
+func TestNewProxies (t *testing.T) {
+  proxies := newTestProxies(t, "x-forwarded-for")
+  if ForwardedForName != proxies.Header {
+    t.Fatalf("Header %q, expected %q", proxies.Header, ForwardedForName)
+  }
+  for _, ip := range []string{ "10.0.0.255", "::1", "::ffff:10.0.0.1" } {
+    if !proxies.Trusted(netip.MustParseAddr(ip).Unmap()) {
+      t.Fatalf("%s not trusted", ip)
+    }
+  }
+  if proxies.Trusted(netip.MustParseAddr("10.0.1.1")) {
+    t.Fatalf("10.0.1.1 trusted")
+  }
+
+  for _, c := range []struct {
+    Ranges []string
+    Header string
+  } {
+    { []string{ "10.0.0.1" }, "" },
+    { []string{ "10.0.0.1" }, "X-Real-IP" },
+    { []string{ "10.0.0.256" }, ForwardedName },
+    { []string{ "10.0.0.0/33" }, ForwardedName },
+  } {
+    if _, err := NewProxies(c.Ranges, c.Header); nil == err {
+      t.Fatalf("Proxies %v with header %q accepted", c.Ranges, c.Header)
+    }
+  }
+  if _, err := NewProxies(nil, ""); nil != err {
+    t.Fatalf("No proxies refused: %v", err)
+  }
+}
+
+// TestParseNode verifies the forms of reported hops that are parsed
+func TestParseNode (t *testing.T) {
+  for node, expected := range map[string]string {
+    "192.0.2.1":             "192.0.2.1",
+    " 192.0.2.1 ":           "192.0.2.1",
+    "192.0.2.1:8080":        "192.0.2.1",
+    "\"192.0.2.1:8080\"":    "192.0.2.1",
+    "\"[2001:db8::1]\"":     "2001:db8::1",
+    "\"[2001:db8::1]:443\"": "2001:db8::1",
+    "2001:db8::1":           "2001:db8::1",
+    "::ffff:192.0.2.1":      "192.0.2.1",
+  } {
+    addr, ok := parseNode(node)
+    if !ok || expected != addr.String() {
+      t.Fatalf("Node %q parsed as %v (%v), expected %s", node, addr, ok,
+        expected)
+    }
+  }
+  for _, node := range []string{ "", "unknown", "_hidden", "192.0.2" } {
+    if _, ok := parseNode(node); ok {
+      t.Fatalf("Node %q parsed", node)
+    }
+  }
+}
+
+// TestForwarded verifies that only the chosen header is read, in order
+func TestForwarded (t *testing.T) {
+  r := newRequest(TestPeer + ":1", map[string][]string {
+    ForwardedName: {
+      "for=192.0.2.1;proto=https, For=\"[2001:db8::1]:443\"",
+      "by=10.0.0.2;for=unknown",
+    },
+    ForwardedForName: { "198.51.100.1, 198.51.100.2", "198.51.100.3" },
+  })
+  for header, expected := range map[string][]string {
+    ForwardedName:    { "192.0.2.1", "\"[2001:db8::1]:443\"", "unknown" },
+    ForwardedForName: { "198.51.100.1", " 198.51.100.2", "198.51.100.3" },
+    "":               {},
+  } {
+    if chain := forwarded(r, header); !slices.Equal(expected, chain) {
+      t.Fatalf("Header %q gave %q, expected %q", header, chain, expected)
+    }
+  }
+  if chain := forwarded(newRequest(TestPeer + ":1", nil),
+    ForwardedName); 0 != len(chain) {
+    t.Fatalf("Missing header gave %q", chain)
+  }
+}
+
+// TestRequestIP verifies that reports are only followed from trusted peers,
+// through trusted hops, and from the chosen header
+func TestRequestIP (t *testing.T) {
+  xff := newTestProxies(t, ForwardedForName)
+  fwd := newTestProxies(t, ForwardedName)
+  cases := []struct {
+    Proxies Proxies
+    Peer    string
+    Headers map[string][]string
+    IP      string
+  } {
+    // No proxies trusted
+    { Proxies{}, TestPeer + ":1",
+      map[string][]string{ ForwardedForName: { "192.0.2.1" } }, TestPeer },
+
+    // Untrusted peer
+    { xff, "192.0.2.9:1",
+      map[string][]string{ ForwardedForName: { "192.0.2.1" } }, "192.0.2.9" },
+
+    // Trusted peer, through a trusted hop
+    { xff, TestPeer + ":1",
+      map[string][]string{ ForwardedForName: { "192.0.2.1, " + TestProxy } },
+      "192.0.2.1" },
+
+    // Forged hop beyond an untrusted one
+    { xff, TestPeer + ":1",
+      map[string][]string{ ForwardedForName: { "10.0.0.3, 192.0.2.1" } },
+      "192.0.2.1" },
+
+    // Only hops that are trusted proxies: the furthest is taken
+    { xff, TestPeer + ":1",
+      map[string][]string{ ForwardedForName: { TestProxy } }, TestProxy },
+
+    // Unparsable hop
+    { xff, TestPeer + ":1",
+      map[string][]string{ ForwardedForName: { "192.0.2.1, unknown" } },
+      TestPeer },
+
+    // The other header is ignored
+    { xff, TestPeer + ":1",
+      map[string][]string{ ForwardedName: { "for=192.0.2.1" } }, TestPeer },
+    { fwd, TestPeer + ":1",
+      map[string][]string{ ForwardedForName: { "192.0.2.1" } }, TestPeer },
+    { fwd, "[::1]:1",
+      map[string][]string {
+        ForwardedName:    { "for=\"[2001:db8::1]:443\"" },
+        ForwardedForName: { "192.0.2.1" },
+      }, "2001:db8::1" },
+
+    // IPv4 mapped peers
+    { xff, "[::ffff:10.0.0.1]:1",
+      map[string][]string{ ForwardedForName: { "192.0.2.1" } }, "192.0.2.1" },
+  }
+  for i, c := range cases {
+    ip, err := RequestIP(newRequest(c.Peer, c.Headers), c.Proxies)
+    if nil != err {
+      t.Fatalf("Case %d failed: %v", i, err)
+    }
+    if c.IP != ip {
+      t.Fatalf("Case %d gave %s, expected %s", i, ip, c.IP)
+    }
+  }
+  if _, err := RequestIP(newRequest("192.0.2.1", nil), xff); nil == err {
+    t.Fatalf("Peer without a port accepted")
+  }
+}
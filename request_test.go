@@ -0,0 +1,82 @@
+package main
+
+import (
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "micrified.com/internal/user"
+  "micrified.com/route"
+  "net/http"
+)
+
+const (
+  Hostname    = "http://localhost:3070"
+  BlogRoute   = "/blog"
+  LoginRoute  = "/login"
+  LogoutRoute = "/logout"
+)
+
+
+/*\
+ *******************************************************************************
+ *                              Generic Functions                              *
+ *******************************************************************************
+\*/
+
+
+// request sends u as JSON with the given headers, verifies the status and
+// unmarshals the response into t if not nil
+func request [T any, U any] (url, method string, header http.Header,
+  status int, u U, t *T) error {
+  var (
+    body   []byte         = []byte{}
+    buffer bytes.Buffer   = bytes.Buffer{}
+    req    *http.Request  = nil
+    res    *http.Response = nil
+    err    error          = nil
+  )
+
+  // Marshal and send request
+  if err = json.NewEncoder(&buffer).Encode(u); nil != err {
+    return err
+  }
+  if req, err = http.NewRequest(method, url, bytes.NewBuffer(buffer.Bytes())); nil != err {
+    return err
+  }
+  for key, values := range header {
+    req.Header[key] = values
+  }
+  req.Header.Set(route.ContentTypeName, route.ContentTypeJSON)
+
+  // Get response and unmarshal if required
+  if res, err = http.DefaultClient.Do(req); nil != err {
+    return err
+  }
+  defer res.Body.Close()
+  if status != res.StatusCode {
+    return fmt.Errorf("Bad status (got %d, expected %d)", res.StatusCode, status)
+  }
+  if nil != t {
+    if body, err = ioutil.ReadAll(res.Body); nil != err {
+      return err
+    }
+    if err = json.Unmarshal(body, t); nil != err {
+      return err
+    }
+  }
+  return nil
+}
+
+// Request is a generic function template
+func Request [T any, U any] (url, method string, status int, u U, t *T) error {
+  return request(url, method, http.Header{}, status, u, t)
+}
+
+// BearerRequest is Request, authorized with the given bearer token
+func BearerRequest [T any, U any] (url, method, token string, status int,
+  u U, t *T) error {
+  header := http.Header{}
+  header.Set(user.AuthorizationName, user.BearerScheme + " " + token)
+  return request(url, method, header, status, u, t)
+}
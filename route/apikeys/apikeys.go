@@ -0,0 +1,278 @@
+package apikeys
+
+import (
+  "context"
+  "database/sql"
+  "fmt"
+  "micrified.com/internal/user"
+  "micrified.com/route"
+  "micrified.com/service/auth"
+  "net/http"
+  "strconv"
+  "strings"
+  "time"
+)
+
+
+// Data: API keys
+type apikeysData struct {
+  TimeFormat, UserTable, APIKeyTable string
+}
+
+// Controller: API keys
+type Controller route.ControllerType[apikeysData]
+
+
+/*\
+ *******************************************************************************
+ *                            Interface: Controller                            *
+ *******************************************************************************
+\*/
+
+
+func NewController (s route.Service) Controller {
+  return Controller {
+    Name:                "apikeys",
+    Methods: map[string]route.Method {
+      http.MethodGet:    route.Restful.Get,
+      http.MethodPost:   route.Restful.Post,
+      http.MethodDelete: route.Restful.Delete,
+    },
+    Secured: map[string]bool {
+      http.MethodGet:    true,
+      http.MethodPost:   true,
+      http.MethodDelete: true,
+    },
+    Access:              map[string][]string{},
+    Service:             s,
+    Limit:               5 * time.Second,
+    Data: apikeysData {
+      TimeFormat:        "2006-01-02 15:04:05",
+      UserTable:         route.UserTable,
+      APIKeyTable:       route.APIKeyTable,
+    },
+  }
+}
+
+func (c *Controller) Route () string {
+  return "/" + c.Name
+}
+
+func (c *Controller) Handler (s string) route.Method {
+  if method, ok := c.Methods[s]; ok {
+    return method
+  }
+  return nil
+}
+
+func (c *Controller) Timeout () time.Duration {
+  return c.Limit
+}
+
+func (c *Controller) Authenticated (s string) bool {
+  return c.Secured[s]
+}
+
+func (c *Controller) Permissions (s string) []string {
+  return c.Access[s]
+}
+
+// Interactive: Keys are only issued to users themselves
+func (c *Controller) Interactive (s string) bool {
+  return http.MethodPost == s
+}
+
+
+/*\
+ *******************************************************************************
+ *                             Interface: Restful                              *
+ *******************************************************************************
+\*/
+
+
+type KeyResponse struct {
+  ID       string   `json:"id"`
+  Name     string   `json:"name"`
+  Prefix   string   `json:"prefix"`
+  Scopes   []string `json:"scopes"`
+  Created  string   `json:"created"`
+  LastUsed string   `json:"last_used,omitempty"`
+  Revoked  bool     `json:"revoked"`
+  Key      string   `json:"key,omitempty"`
+}
+
+// Get lists the API keys of the authenticated user
+func (c *Controller) Get (x context.Context, rq *http.Request, re *route.Result) error {
+  var (
+    list     []KeyResponse = []KeyResponse{}
+    username string        = ""
+    ok       bool          = false
+  )
+
+  // Credentials were resolved ahead of the controller
+  if username, ok = user.Name(x); !ok {
+    return route.Unauthorized(fmt.Errorf("Not authenticated"))
+  }
+
+  q := fmt.Sprintf("SELECT b.id, b.name, b.prefix, b.scopes, b.created, " +
+                   "b.last_used, b.revoked " +
+                   "FROM %s AS a INNER JOIN %s AS b " +
+                   "ON a.id = b.user_id " +
+                   "WHERE a.username = ? ORDER BY b.id",
+                   c.Data.UserTable, c.Data.APIKeyTable)
+
+  // Extract rows
+  rows, err := c.Service.Database.DB.QueryContext(x, q, username)
+  if nil != err {
+    return route.Internal(err)
+  }
+  defer rows.Close()
+
+  // Marshall rows
+  for rows.Next() {
+    var (
+      id       int64
+      scopes   string
+      lastUsed sql.NullString
+      head     KeyResponse
+    )
+    if err = rows.Scan(&id, &head.Name, &head.Prefix, &scopes, &head.Created,
+      &lastUsed, &head.Revoked); nil != err {
+      return route.Internal(err)
+    }
+    head.ID = strconv.FormatInt(id, 10)
+    head.Scopes = strings.Fields(scopes)
+    head.LastUsed = lastUsed.String
+    list = append(list, head)
+  }
+  if err = rows.Err(); nil != err {
+    return route.Internal(err)
+  }
+
+  return re.Marshal(&list)
+}
+
+type KeyPost struct {
+  Name   string   `json:"name"   validate:"required,max=255"`
+  Scopes []string `json:"scopes" validate:"required"`
+}
+
+// Post issues a new API key to the authenticated user. The key may only be
+// scoped to permissions the user holds. The key is only ever returned here
+func (c *Controller) Post (x context.Context, rq *http.Request, re *route.Result) error {
+  var (
+    err       error     = nil
+    post      KeyPost   = KeyPost{}
+    timeStamp time.Time = time.Now().UTC()
+    username  string    = ""
+    ok        bool      = false
+  )
+
+  // Credentials were resolved ahead of the controller
+  if username, ok = user.Name(x); !ok {
+    return route.Unauthorized(fmt.Errorf("Not authenticated"))
+  }
+
+  // Decode and validate request body
+  if err = route.Decode(rq, &post); nil != err {
+    return err
+  }
+
+  // Scopes may not exceed the permissions of the user
+  for _, scope := range post.Scopes {
+    if !user.Permitted(x, scope) {
+      return route.Forbidden(fmt.Errorf("Scope %q not permitted", scope))
+    }
+  }
+
+  // Issue key
+  key, prefix, hash, salt, err := auth.NewAPIKey()
+  if nil != err {
+    return route.Internal(err)
+  }
+
+  q := fmt.Sprintf("INSERT INTO %s " +
+                   "(user_id, name, prefix, hash, salt, scopes, created, revoked) " +
+                   "SELECT id, ?, ?, ?, ?, ?, ?, FALSE FROM %s WHERE username = ?",
+                   c.Data.APIKeyTable, c.Data.UserTable)
+  r, err := c.Service.Database.DB.ExecContext(x, q, post.Name, prefix,
+    auth.ToByteSlice(hash), auth.ToByteSlice(salt),
+    strings.Join(post.Scopes, " "), timeStamp, username)
+  if nil != err {
+    return route.Internal(err)
+  }
+  id, err := r.LastInsertId()
+  if nil != err {
+    return route.Internal(err)
+  }
+
+  return re.Marshal(
+    &KeyResponse {
+      ID:      strconv.FormatInt(id, 10),
+      Name:    post.Name,
+      Prefix:  prefix,
+      Scopes:  post.Scopes,
+      Created: timeStamp.Format(c.Data.TimeFormat),
+      Revoked: false,
+      Key:     key,
+    })
+}
+
+func (c *Controller) Put (x context.Context, rq *http.Request, re *route.Result) error {
+  return re.Unimplemented()
+}
+
+type KeyDelete struct {
+  ID string `json:"id" validate:"required,numeric"`
+}
+
+// Delete revokes an API key of the authenticated user. Users permitted to
+// manage users may revoke the keys of any user
+func (c *Controller) Delete (x context.Context, rq *http.Request, re *route.Result) error {
+  var (
+    err      error     = nil
+    target   KeyDelete = KeyDelete{}
+    username string    = ""
+    ok       bool      = false
+    owned    string    = ""
+    args     []any     = []any{}
+  )
+
+  // Credentials were resolved ahead of the controller
+  if username, ok = user.Name(x); !ok {
+    return route.Unauthorized(fmt.Errorf("Not authenticated"))
+  }
+
+  // Take the identifier from the query if given; else decode the body
+  if id := rq.URL.Query().Get("id"); "" != id {
+    target.ID = id
+    err = route.Validate(&target)
+  } else {
+    err = route.Decode(rq, &target)
+  }
+  if nil != err {
+    return err
+  }
+  args = append(args, target.ID)
+
+  // Restrict to keys of the user unless managing users
+  if !user.Permitted(x, auth.PermissionUsersManage) {
+    owned = fmt.Sprintf(" AND user_id = (SELECT id FROM %s WHERE username = ?)",
+      c.Data.UserTable)
+    args = append(args, username)
+  }
+
+  q := fmt.Sprintf("UPDATE %s SET revoked = TRUE WHERE id = ?%s",
+    c.Data.APIKeyTable, owned)
+  r, err := c.Service.Database.DB.ExecContext(x, q, args...)
+  if nil != err {
+    return route.Internal(err)
+  }
+  if rows, err := r.RowsAffected(); nil != err {
+    return route.Internal(err)
+  } else if 0 == rows {
+    return route.NotFound(fmt.Errorf("No API key %s", target.ID))
+  }
+
+  return re.NoContent()
+}
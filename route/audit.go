@@ -0,0 +1,88 @@
+package route
+
+import (
+  "context"
+  "fmt"
+  "log"
+  "micrified.com/internal/user"
+  "net/http"
+  "time"
+)
+
+const (
+  AuditTable   = "audit_log"
+  AuditTimeout = 5 * time.Second
+)
+
+const (
+  ActionRequest    = "request"
+  ActionLogin      = "login"
+  ActionRebind     = "session.rebind"
+  ActionLogout     = "logout"
+  ActionBlogCreate = "blog.create"
+  ActionBlogUpdate = "blog.update"
+  ActionBlogDelete = "blog.delete"
+)
+
+const (
+  OutcomeSuccess = "success"
+  OutcomeFailure = "failure"
+  OutcomeDenied  = "denied"
+)
+
+
+/*\
+ *******************************************************************************
+ *                               Definition: Audit                             *
+ *******************************************************************************
+\*/
+
+
+// Event: A security relevant event. The actor is the (claimed) username, and
+// the target names what was acted upon, if anything
+type Event struct {
+  Actor   string
+  IP      string
+  Action  string
+  Target  string
+  Outcome string
+}
+
+// Audit appends the event to the audit log. A failure to record the event
+// is logged, but does not fail the request. Events are recorded even when the
+// request was cancelled, within AuditTimeout
+func Audit (s Service, x context.Context, z Event) {
+  x, cancel := context.WithTimeout(context.WithoutCancel(x), AuditTimeout)
+  defer cancel()
+  q := fmt.Sprintf("INSERT INTO %s (time, actor, ip, action, target, outcome) " +
+    "VALUES (?,?,?,?,?,?)", AuditTable)
+  _, err := s.Database.DB.ExecContext(x, q, time.Now().UTC(), z.Actor, z.IP,
+    z.Action, z.Target, z.Outcome)
+  if nil != err {
+    log.Printf("Audit %+v: %v\n", z, err)
+  }
+}
+
+// Outcome returns the outcome described by the error the request ended with
+func Outcome (err error) string {
+  switch status := Status(err); {
+  case status < http.StatusBadRequest:
+    return OutcomeSuccess
+  case http.StatusUnauthorized == status, http.StatusForbidden == status:
+    return OutcomeDenied
+  }
+  return OutcomeFailure
+}
+
+// AuditRequest appends an event for the authenticated user of the request
+func AuditRequest (s Service, x context.Context, action, target, outcome string) {
+  actor, _ := user.Name(x)
+  ip, _ := x.Value(user.UserIPKey).(string)
+  Audit(s, x, Event {
+    Actor:   actor,
+    IP:      ip,
+    Action:  action,
+    Target:  target,
+    Outcome: outcome,
+  })
+}
@@ -0,0 +1,189 @@
+package audit
+
+import (
+  "context"
+  "fmt"
+  "micrified.com/route"
+  "micrified.com/service/auth"
+  "net/http"
+  "strconv"
+  "strings"
+  "time"
+)
+
+
+// Data: Audit
+type auditData struct {
+  TimeFormat             string
+  DefaultLimit, MaxLimit int
+}
+
+// Controller: Audit
+type Controller route.ControllerType[auditData]
+
+
+/*\
+ *******************************************************************************
+ *                            Interface: Controller                            *
+ *******************************************************************************
+\*/
+
+
+func NewController (s route.Service) Controller {
+  return Controller {
+    Name:                "audit",
+    Methods: map[string]route.Method {
+      http.MethodGet:    route.Restful.Get,
+    },
+    Secured: map[string]bool {
+      http.MethodGet:    true,
+    },
+    Access: map[string][]string {
+      http.MethodGet:    { auth.PermissionAuditRead },
+    },
+    Service:             s,
+    Limit:               5 * time.Second,
+    Data: auditData {
+      TimeFormat:        "2006-01-02 15:04:05",
+      DefaultLimit:      100,
+      MaxLimit:          1000,
+    },
+  }
+}
+
+func (c *Controller) Route () string {
+  return "/" + c.Name
+}
+
+func (c *Controller) Handler (s string) route.Method {
+  if method, ok := c.Methods[s]; ok {
+    return method
+  }
+  return nil
+}
+
+func (c *Controller) Timeout () time.Duration {
+  return c.Limit
+}
+
+func (c *Controller) Authenticated (s string) bool {
+  return c.Secured[s]
+}
+
+func (c *Controller) Permissions (s string) []string {
+  return c.Access[s]
+}
+
+
+/*\
+ *******************************************************************************
+ *                             Interface: Restful                              *
+ *******************************************************************************
+\*/
+
+
+type EventResponse struct {
+  ID      string `json:"id"`
+  Time    string `json:"time"`
+  Actor   string `json:"actor"`
+  IP      string `json:"ip"`
+  Action  string `json:"action"`
+  Target  string `json:"target"`
+  Outcome string `json:"outcome"`
+}
+
+// Get lists audit events, newest first. The query may filter by actor,
+// action, and a time range (since, until), and bound the number of events
+func (c *Controller) Get (x context.Context, rq *http.Request, re *route.Result) error {
+  var (
+    list  []EventResponse = []EventResponse{}
+    where []string        = []string{}
+    args  []any           = []any{}
+    limit int             = c.Data.DefaultLimit
+    query                 = rq.URL.Query()
+  )
+
+  // Exact match filters
+  for _, column := range []string{ "actor", "action" } {
+    if value := query.Get(column); "" != value {
+      where = append(where, column + " = ?")
+      args = append(args, value)
+    }
+  }
+
+  // Time range filters
+  for column, op := range map[string]string{ "since": ">=", "until": "<=" } {
+    value := query.Get(column)
+    if "" == value {
+      continue
+    }
+    t, err := c.parseTime(value)
+    if nil != err {
+      return route.BadRequest(fmt.Errorf("Bad %s: %w", column, err))
+    }
+    where = append(where, "time " + op + " ?")
+    args = append(args, t)
+  }
+
+  // Bound the number of events
+  if value := query.Get("limit"); "" != value {
+    n, err := strconv.Atoi(value)
+    if nil != err || n < 1 {
+      return route.BadRequest(fmt.Errorf("Bad limit: %s", value))
+    }
+    limit = min(n, c.Data.MaxLimit)
+  }
+
+  q := fmt.Sprintf("SELECT id, time, actor, ip, action, target, outcome " +
+                   "FROM %s", route.AuditTable)
+  if len(where) > 0 {
+    q += " WHERE " + strings.Join(where, " AND ")
+  }
+  q += " ORDER BY id DESC LIMIT ?"
+
+  // Extract rows
+  rows, err := c.Service.Database.DB.QueryContext(x, q, append(args, limit)...)
+  if nil != err {
+    return route.Internal(err)
+  }
+  defer rows.Close()
+
+  // Marshall rows
+  for rows.Next() {
+    var (
+      id    int64
+      event EventResponse
+    )
+    if err = rows.Scan(&id, &event.Time, &event.Actor, &event.IP,
+      &event.Action, &event.Target, &event.Outcome); nil != err {
+      return route.Internal(err)
+    }
+    event.ID = strconv.FormatInt(id, 10)
+    list = append(list, event)
+  }
+  if err = rows.Err(); nil != err {
+    return route.Internal(err)
+  }
+
+  return re.Marshal(&list)
+}
+
+func (c *Controller) Post (x context.Context, rq *http.Request, re *route.Result) error {
+  return re.Unimplemented()
+}
+
+func (c *Controller) Put (x context.Context, rq *http.Request, re *route.Result) error {
+  return re.Unimplemented()
+}
+
+func (c *Controller) Delete (x context.Context, rq *http.Request, re *route.Result) error {
+  return re.Unimplemented()
+}
+
+// parseTime accepts RFC 3339 or the stored time format (taken as UTC)
+func (c *Controller) parseTime (s string) (time.Time, error) {
+  if t, err := time.Parse(time.RFC3339, s); nil == err {
+    return t.UTC(), nil
+  }
+  return time.Parse(c.Data.TimeFormat, s)
+}
@@ -3,9 +3,11 @@ package blog
 import (
   "context"
   "database/sql"
-  "encoding/json"
+  "encoding/xml"
+  "errors"
   "fmt"
-  "io/ioutil"
+  "html/template"
+  "io"
   "micrified.com/internal/user"
   "micrified.com/route"
   "micrified.com/service/auth"
@@ -14,9 +16,14 @@ import (
   "time"
 )
 
+const (
+  FeedTitle = "micrified.com"
+  FeedLink  = "https://micrified.com"
+)
+
 // Data: Blog
 type blogData struct {
-  TimeFormat, PageTable, ContentTable string
+  TimeFormat, PageTable, ContentTable, UserTable string
 }
 
 // Controller: Blog
@@ -39,12 +46,23 @@ func NewController (s route.Service) Controller {
       http.MethodPut:    route.Restful.Put,
       http.MethodDelete: route.Restful.Delete,
     },
+    Secured: map[string]bool {
+      http.MethodPost:   true,
+      http.MethodPut:    true,
+      http.MethodDelete: true,
+    },
+    Access: map[string][]string {
+      http.MethodPost:   { auth.PermissionBlogWrite, auth.PermissionBlogWriteOwn },
+      http.MethodPut:    { auth.PermissionBlogWrite, auth.PermissionBlogWriteOwn },
+      http.MethodDelete: { auth.PermissionBlogWrite, auth.PermissionBlogWriteOwn },
+    },
     Service:             s,
     Limit:               5 * time.Second,
     Data: blogData {
       TimeFormat:        "2006-01-02 15:04:05",
       PageTable:         "blog_pages",
       ContentTable:      "page_content",
+      UserTable:         "users",
     },
   }
 }
@@ -53,6 +71,15 @@ func (c *Controller) Route () string {
   return "/" + c.Name
 }
 
+func (c *Controller) Patterns () []string {
+  return []string{ c.Route() + "/{id}" }
+}
+
+// BodyLimit admits pages larger than the default limit
+func (c *Controller) BodyLimit () int64 {
+  return 4 << 20
+}
+
 func (c *Controller) Handler (s string) route.Method {
   if method, ok := c.Methods[s]; ok {
     return method
@@ -64,6 +91,14 @@ func (c *Controller) Timeout () time.Duration {
   return c.Limit
 }
 
+func (c *Controller) Authenticated (s string) bool {
+  return c.Secured[s]
+}
+
+func (c *Controller) Permissions (s string) []string {
+  return c.Access[s]
+}
+
 
 /*\
  *******************************************************************************
@@ -84,19 +119,25 @@ type BlogHeader struct {
 func (c *Controller) Get (x context.Context, rq *http.Request, re *route.Result) error {
   var (
     head BlogHeader
-    list []BlogHeader
+    list BlogList
   )
+
+  // A single page (with its body) if one is identified by the path
+  if route.HasPath(rq, "id") {
+    return c.page(x, rq, re)
+  }
+
   q := fmt.Sprintf("SELECT a.id, a.title, a.subtitle, a.tag, b.created, b.updated " +
                    "FROM %s AS a INNER JOIN %s as b " + 
                    "ON a.content_id = b.id " +
                    "ORDER BY b.created", c.Data.PageTable, c.Data.ContentTable)
 
   // Extract rows
-  rows, err := c.Service.Database.DB.Query(q)
-  defer rows.Close()
+  rows, err := c.Service.Database.DB.QueryContext(x, q)
   if nil != err {
-    return err
+    return route.Internal(err)
   }
+  defer rows.Close()
 
   // Marshall rows
   for rows.Next() {
@@ -109,19 +150,49 @@ func (c *Controller) Get (x context.Context, rq *http.Request, re *route.Result)
   }
 
   // Check error
+  if nil == err {
+    err = rows.Err()
+  }
   if nil != err {
-    return err
+    return route.Internal(err)
   }
 
   // Write to buffer and return any encoding error
-  return re.Marshal(route.ContentTypeJSON, &list)
+  return re.Marshal(&list)
+}
+
+// page writes the page identified by the path, with its body
+func (c *Controller) page (x context.Context, rq *http.Request,
+  re *route.Result) error {
+  var post BlogPostResponse
+
+  id, err := route.PathInt(rq, "id")
+  if nil != err {
+    return route.BadRequest(err)
+  }
+
+  q := fmt.Sprintf("SELECT a.id, a.title, a.subtitle, a.tag, b.body, " +
+                   "b.created, b.updated " +
+                   "FROM %s AS a INNER JOIN %s AS b " +
+                   "ON a.content_id = b.id " +
+                   "WHERE a.id = ?", c.Data.PageTable, c.Data.ContentTable)
+
+  err = c.Service.Database.DB.QueryRowContext(x, q, id).Scan(&post.ID,
+    &post.Title, &post.Subtitle, &post.Tag, &post.Body, &post.Created, &post.Updated)
+  if errors.Is(err, sql.ErrNoRows) {
+    return route.NotFound(fmt.Errorf("No page %d", id))
+  } else if nil != err {
+    return route.Internal(err)
+  }
+
+  return re.Marshal(&post)
 }
 
 type BlogPost struct {
-  Title    string `json:"title"`
-  Subtitle string `json:"subtitle"`
-  Tag      string `json:"tag"`
-  Body     string `json:"body"`
+  Title    string `json:"title"    validate:"required,max=255"`
+  Subtitle string `json:"subtitle" validate:"max=255"`
+  Tag      string `json:"tag"      validate:"max=64"`
+  Body     string `json:"body"     validate:"required"`
 }
 
 type BlogPostResponse struct {
@@ -134,41 +205,31 @@ type BlogPostResponse struct {
   Updated  string `json:"updated"`
 }
 
-func (c *Controller) Post (x context.Context, rq *http.Request, re *route.Result) error {
+func (c *Controller) Post (x context.Context, rq *http.Request, re *route.Result) (err error) {
   var (
-    body      []byte                  = []byte{}
-    err       error                   = nil
-    ip        string                  = x.Value(user.UserIPKey).(string)
-    post      auth.AuthData[BlogPost] = auth.AuthData[BlogPost]{}
-    timeStamp time.Time               = time.Now().UTC()
+    post      BlogPost  = BlogPost{}
+    target    string    = ""
+    timeStamp time.Time = time.Now().UTC()
+    author, _           = user.Name(x)
   )
 
-  fail := func (err error, status int) error {
-    re.Status = status
-    return err
-  }
+  // Record the outcome, however the request ends
+  defer func () {
+    route.AuditRequest(c.Service, x, route.ActionBlogCreate, target,
+      route.Outcome(err))
+  }()
   
-  // Read request body
-  if body, err = ioutil.ReadAll(rq.Body); nil != err {
-    return fail(err, http.StatusInternalServerError)
-  }
-
-  // Unmarshal to type
-  if err = json.Unmarshal(body, &post); nil != err {
-    return fail(err, http.StatusBadRequest)
+  // Decode and validate request body
+  if err = route.Decode(rq, &post); nil != err {
+    return err
   }
 
-  // Check if authorized
-  if err = c.Service.Auth.Authorized(ip, post.Username, post.Secret); nil != err {
-    return fail(err, http.StatusUnauthorized)
-  }
-    
   // Define insert content
   insertBody := func (lastResult sql.Result, t *sql.Tx) (sql.Result, error) {
     q := fmt.Sprintf("INSERT INTO %s (created,updated,body) VALUES (?,?,?)",
       c.Data.ContentTable)
-    return t.ExecContext(c.Service.Database.Context, q, timeStamp, timeStamp,
-      post.Data.Body)
+    return t.ExecContext(x, q, timeStamp, timeStamp,
+      post.Body)
   }
 
   // Define insert record
@@ -177,43 +238,46 @@ func (c *Controller) Post (x context.Context, rq *http.Request, re *route.Result
     if nil != err {
       return nil, err
     }
-    q := fmt.Sprintf("INSERT INTO %s (title,subtitle,tag,content_id) " +
-      "VALUES (?,?,?,?)", c.Data.PageTable)
-    return t.ExecContext(c.Service.Database.Context, q, post.Data.Title, 
-      post.Data.Subtitle, post.Data.Tag, id)
+    q := fmt.Sprintf("INSERT INTO %s (title,subtitle,tag,content_id,author_id) " +
+      "SELECT ?,?,?,?,id FROM %s WHERE username = ?", c.Data.PageTable,
+      c.Data.UserTable)
+    return t.ExecContext(x, q, post.Title, 
+      post.Subtitle, post.Tag, id, author)
   }
 
   // Execute sequenced insert operations; get back result
-  r, err := c.Service.Database.Transaction(insertBody, insertRecord)
+  r, err := c.Service.Database.Transaction(x, insertBody, insertRecord)
   if nil != err {
-    return fail(err, http.StatusInternalServerError)
+    return route.Internal(err)
   }
 
   // Get the record ID
   id, err := r.LastInsertId()
   if nil != err {
-    return fail(err, http.StatusInternalServerError)
+    return route.Internal(err)
   }
+  target = strconv.FormatInt(id, 10)
 
   // Write to buffer and return any encoding error
-  return re.Marshal(route.ContentTypeJSON, 
+  return re.Marshal(
     &BlogPostResponse {
-      ID:       strconv.FormatInt(id, 10),
-      Title:    post.Data.Title,
-      Subtitle: post.Data.Subtitle,
-      Tag:      post.Data.Tag,
-      Body:     post.Data.Body,
+      ID:       target,
+      Title:    post.Title,
+      Subtitle: post.Subtitle,
+      Tag:      post.Tag,
+      Body:     post.Body,
       Created:  timeStamp.Format(c.Data.TimeFormat),
       Updated:  timeStamp.Format(c.Data.TimeFormat),
     })
 }
 
+// BlogPut: The identifier may instead be given by the path
 type BlogPut struct {
-  ID       string `json:"id"`
-  Title    string `json:"title"`
-  Subtitle string `json:"subtitle"`
-  Tag      string `json:"tag"`
-  Body     string `json:"body"`
+  ID       string `json:"id"       validate:"numeric"`
+  Title    string `json:"title"    validate:"required,max=255"`
+  Subtitle string `json:"subtitle" validate:"max=255"`
+  Tag      string `json:"tag"      validate:"max=64"`
+  Body     string `json:"body"     validate:"required"`
 }
 
 type BlogPutResponse struct {
@@ -225,127 +289,234 @@ type BlogPutResponse struct {
   Body     string `json:"body"`
 }
 
-func (c *Controller) Put (x context.Context, rq *http.Request, re *route.Result) error {
+func (c *Controller) Put (x context.Context, rq *http.Request, re *route.Result) (err error) {
   var (
-    body      []byte                 = []byte{}
-    err       error                  = nil
-    ip        string                 = x.Value(user.UserIPKey).(string)
-    post      auth.AuthData[BlogPut] = auth.AuthData[BlogPut]{}
-    timeStamp time.Time              = time.Now().UTC()
+    post      BlogPut   = BlogPut{}
+    timeStamp time.Time = time.Now().UTC()
+    owned, by           = c.owner(x)
   )
 
-  fail := func (err error, status int) error {
-    re.Status = status
-    return err
-  }
+  // Record the outcome, however the request ends
+  defer func () {
+    route.AuditRequest(c.Service, x, route.ActionBlogUpdate, post.ID,
+      route.Outcome(err))
+  }()
 
   // Define update record
   updateRecord := func (lastResult sql.Result, conn *sql.Conn) (sql.Result, error) {
     q := fmt.Sprintf("UPDATE %s AS a INNER JOIN %s AS b ON a.content_id = b.id " +
                      "SET a.title = ?, a.subtitle = ?, b.updated = ?, b.body = ? " +
-		     "WHERE a.id = ?", c.Data.PageTable, c.Data.ContentTable)
-    return conn.ExecContext(c.Service.Database.Context, q, post.Data.Title, 
-      post.Data.Subtitle, timeStamp, post.Data.Body, post.Data.ID)
-  }
-
-  // Read request body
-  if body, err = ioutil.ReadAll(rq.Body); nil != err {
-    return fail(err, http.StatusInternalServerError)
+		     "WHERE a.id = ?%s", c.Data.PageTable, c.Data.ContentTable, owned)
+    return conn.ExecContext(x, q, append([]any {
+      post.Title, post.Subtitle, timeStamp, post.Body, post.ID }, by...)...)
   }
 
-  // Unmarshal to type
-  if err = json.Unmarshal(body, &post); nil != err {
-    return fail(err, http.StatusBadRequest)
+  // Decode and validate request body
+  if err = route.Decode(rq, &post); nil != err {
+    return err
   }
 
-  // Check if authorized
-  if err = c.Service.Auth.Authorized(ip, post.Username, post.Secret); nil != err {
-    return fail(err, http.StatusUnauthorized)
+  // Take the identifier from the path if given
+  if route.HasPath(rq, "id") {
+    id, err := route.PathInt(rq, "id")
+    if nil != err {
+      return route.BadRequest(err)
+    }
+    post.ID = strconv.FormatInt(id, 10)
+  } else if "" == post.ID {
+    return route.Validation(map[string]string{ "id": "is required" })
   }
 
   // Execute sequenced connection operations; get back result
-  r, err := c.Service.Database.Connection(updateRecord)
+  r, err := c.Service.Database.Connection(x, updateRecord)
   if nil != err {
-    return fail(err, http.StatusInternalServerError)
+    return route.Internal(err)
   }
 
   // Verify the right number of rows were affected
   rows, err := r.RowsAffected()
   if nil != err {
-    return fail(err, http.StatusInternalServerError)
+    return route.Internal(err)
+  } else if 0 == rows && "" != owned {
+    return route.Forbidden(fmt.Errorf("No page %s written by you", post.ID))
   } else if 0 == rows {
-    return fail(fmt.Errorf("Unexpected database result (no rows modified)"), 
-      http.StatusInternalServerError)
+    return route.NotFound(fmt.Errorf("No page %s", post.ID))
   }
 
   // No difference is needed here in the return type
-  return re.Marshal(route.ContentTypeJSON,
+  return re.Marshal(
     &BlogPutResponse {
-      ID:       post.Data.ID,
-      Title:    post.Data.Title,
-      Subtitle: post.Data.Subtitle,
-      Tag:      post.Data.Tag,
+      ID:       post.ID,
+      Title:    post.Title,
+      Subtitle: post.Subtitle,
+      Tag:      post.Tag,
       Updated:  timeStamp.Format(c.Data.TimeFormat),
-      Body:     post.Data.Body,
+      Body:     post.Body,
     })
 }
 
 type BlogDelete struct {
-  ID string `json:"id"`
+  ID string `json:"id" validate:"required,numeric"`
 }
 
-func (c *Controller) Delete (x context.Context, rq *http.Request, re *route.Result) error {
+func (c *Controller) Delete (x context.Context, rq *http.Request, re *route.Result) (err error) {
   var (
-    body  []byte                    = []byte{}
-    err   error                     = nil
-    ip    string                    = x.Value(user.UserIPKey).(string)
-    post  auth.AuthData[BlogDelete] = auth.AuthData[BlogDelete]{}
+    post  BlogDelete = BlogDelete{}
+    owned, by        = c.owner(x)
   )
 
-  fail := func (err error, status int) error {
-    re.Status = status
-    return err
-  }
+  // Record the outcome, however the request ends
+  defer func () {
+    route.AuditRequest(c.Service, x, route.ActionBlogDelete, post.ID,
+      route.Outcome(err))
+  }()
 
   // Define delete record
   deleteRecord := func (lastResult sql.Result, conn *sql.Conn) (sql.Result, error) {
     q := fmt.Sprintf("DELETE a, b FROM %s AS a INNER JOIN %s AS b " +
                      "ON a.content_id = b.id " +
-                     "WHERE a.id = ?", c.Data.PageTable, c.Data.ContentTable)
-    return conn.ExecContext(c.Service.Database.Context, q, post.Data.ID)
+                     "WHERE a.id = ?%s", c.Data.PageTable, c.Data.ContentTable,
+                     owned)
+    return conn.ExecContext(x, q,
+      append([]any{ post.ID }, by...)...)
   }
 
-  // Read request body
-  if body, err = ioutil.ReadAll(rq.Body); nil != err {
-    return fail(err, http.StatusInternalServerError)
-  }
-
-  // Unmarshal to type
-  if err = json.Unmarshal(body, &post); nil != err {
-    return fail(err, http.StatusBadRequest)
+  // Take the identifier from the path or query if given; else decode
+  if route.HasPath(rq, "id") {
+    id, err := route.PathInt(rq, "id")
+    if nil != err {
+      return route.BadRequest(err)
+    }
+    post.ID = strconv.FormatInt(id, 10)
+  } else if id := rq.URL.Query().Get("id"); "" != id {
+    post.ID = id
+    err = route.Validate(&post)
+  } else {
+    err = route.Decode(rq, &post)
   }
-
-  // Check if authorized
-  if err = c.Service.Auth.Authorized(ip, post.Username, post.Secret); nil != err {
-    return fail(err, http.StatusUnauthorized)
+  if nil != err {
+    return err
   }
 
-
   // Execute sequenced connection operations; get back result
-  r, err := c.Service.Database.Connection(deleteRecord)
+  r, err := c.Service.Database.Connection(x, deleteRecord)
   if nil != err {
-    fail(err, http.StatusInternalServerError)
+    return route.Internal(err)
   }
 
   // Verify the right number of rows were affected
   rows, err := r.RowsAffected()
   if nil != err {
-    fail(err, http.StatusInternalServerError)
+    return route.Internal(err)
+  } else if 0 == rows && "" != owned {
+    return route.Forbidden(fmt.Errorf("No page %s written by you", post.ID))
+  } else if 0 == rows {
+    return route.NotFound(fmt.Errorf("No page %s", post.ID))
   } else if 2 != rows {
-    fail(fmt.Errorf("Unexpected database result (expected %d rows affected, got %d)",
-      2, rows), http.StatusInternalServerError)
+    return route.Internal(fmt.Errorf("Unexpected database result " +
+      "(expected %d rows affected, got %d)", 2, rows))
   }
 
   return nil
 }
 
+// owner returns the condition (and its arguments) restricting a query on
+// pages to those written by the authenticated user. The condition is empty
+// if the user may write any page
+func (c *Controller) owner (x context.Context) (string, []any) {
+  if user.Permitted(x, auth.PermissionBlogWrite) {
+    return "", []any{}
+  }
+  username, _ := user.Name(x)
+  return fmt.Sprintf(" AND a.author_id = (SELECT id FROM %s WHERE username = ?)",
+    c.Data.UserTable), []any{ username }
+}
+
+
+/*\
+ *******************************************************************************
+ *                         Definition: Representations                         *
+ *******************************************************************************
+\*/
+
+
+// BlogList: Pages, as listed by Get. Also encoded as an RSS feed
+type BlogList []BlogHeader
+
+type rssFeed struct {
+  XMLName xml.Name   `xml:"rss"`
+  Version string     `xml:"version,attr"`
+  Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+  Title       string    `xml:"title"`
+  Link        string    `xml:"link"`
+  Description string    `xml:"description"`
+  Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+  Title       string  `xml:"title"`
+  Link        string  `xml:"link"`
+  Description string  `xml:"description,omitempty"`
+  Category    string  `xml:"category,omitempty"`
+  GUID        rssGUID `xml:"guid"`
+  PubDate     string  `xml:"pubDate,omitempty"`
+}
+
+type rssGUID struct {
+  IsPermaLink bool   `xml:"isPermaLink,attr"`
+  Value       string `xml:",chardata"`
+}
+
+// Feed returns the pages as an RSS 2.0 feed, newest first
+func (l BlogList) Feed () any {
+  feed := rssFeed {
+    Version: "2.0",
+    Channel: rssChannel {
+      Title:       FeedTitle,
+      Link:        FeedLink,
+      Description: "Pages of " + FeedTitle,
+      Items:       []rssItem{},
+    },
+  }
+  for i := len(l) - 1; i >= 0; i-- {
+    link := FeedLink + "/blog/" + l[i].ID
+    item := rssItem {
+      Title:       l[i].Title,
+      Link:        link,
+      Description: l[i].Subtitle,
+      Category:    l[i].Tag,
+      GUID:        rssGUID{ IsPermaLink: true, Value: link },
+    }
+    if t, err := time.Parse(time.DateTime, l[i].Created); nil == err {
+      item.PubDate = t.Format(time.RFC1123Z)
+    }
+    feed.Channel.Items = append(feed.Channel.Items, item)
+  }
+  return &feed
+}
+
+var pageTemplate *template.Template = template.Must(
+  template.New("page").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+</head>
+<body>
+<article>
+<h1>{{.Title}}</h1>
+{{if .Subtitle}}<h2>{{.Subtitle}}</h2>
+{{end}}<p><time>{{.Created}}</time>{{if .Tag}} &middot; {{.Tag}}{{end}}</p>
+<div style="white-space: pre-wrap">{{.Body}}</div>
+</article>
+</body>
+</html>
+`))
+
+// HTML writes the page as a document of its own. The body is escaped
+func (p *BlogPostResponse) HTML (w io.Writer) error {
+  return pageTemplate.Execute(w, p)
+}
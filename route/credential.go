@@ -0,0 +1,215 @@
+package route
+
+import (
+  "bytes"
+  "context"
+  "database/sql"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "io/ioutil"
+  "micrified.com/internal/user"
+  "micrified.com/service/auth"
+  "net/http"
+  "strings"
+  "time"
+)
+
+
+/*\
+ *******************************************************************************
+ *                          Definition: Credentials                            *
+ *******************************************************************************
+\*/
+
+
+// KeyUseInterval: The resolution to which API key use is recorded
+const KeyUseInterval = 1 * time.Minute
+
+// keysUsed: When the use of each API key was last recorded (by key id)
+var keysUsed auth.SyncMap[int64, time.Time] = auth.NewSyncMap[int64, time.Time]()
+
+
+// Authenticate resolves the credentials of a request once, on behalf of the
+// controller. A bearer token (supplied in the Authorization header, or in the
+// session cookie) is preferred. Otherwise, if the controller secures the
+// request method, the legacy auth.AuthData body is unwrapped: its credentials
+// are verified, and the request body is replaced with the enclosed data.
+// API keys are accepted as bearer tokens too, except by Interactive methods.
+// On success the username (and
+// any scopes carried by a signed access token or API key) are attached to
+// the returned context. Unsecured methods never fail because
+// of a missing or stale token
+func Authenticate (s Service, c Controller, x context.Context,
+  rq *http.Request, re *Result) (context.Context, error) {
+  var (
+    body     []byte                         = []byte{}
+    err      error                          = nil
+    client   auth.Client                    = Client(x)
+    legacy   auth.AuthData[json.RawMessage] = auth.AuthData[json.RawMessage]{}
+    secured  bool                           = c.Authenticated(rq.Method)
+    identity auth.Identity                  = auth.Identity{}
+  )
+
+  // Case: Bearer token (or API key) supplied
+  if token, ok := user.RequestToken(rq, s.Auth.Cookie()); ok {
+    if auth.IsAPIKey(token) {
+      identity, err = Key(s, x, token)
+    } else {
+      identity, err = s.Auth.Identify(x, client, token)
+    }
+    if nil == err && identity.Key && interactive(c, rq.Method) {
+      return x, Forbidden(fmt.Errorf("API keys are not accepted here"))
+    }
+    if nil == err {
+      x = user.ContextWithName(x, identity.Username)
+      if identity.Signed || identity.Key {
+        x = user.ContextWithPermissions(x, identity.Scopes)
+      }
+      return x, nil
+    } else if secured {
+      if errors.Is(err, auth.ErrRebind) {
+        re.Header.Set(AuthenticateName,
+          `Bearer error="invalid_token", error_description="re-authenticate"`)
+      }
+      return x, Unauthorized(err)
+    }
+    return x, nil
+  }
+
+  // Case: No credentials required
+  if !secured {
+    return x, nil
+  }
+
+  // Case: Legacy credentials embedded in the request body
+  if body, err = ReadBody(rq); nil != err {
+    return x, err
+  }
+  if err = json.Unmarshal(body, &legacy); nil != err {
+    return x, BadRequest(fmt.Errorf("Missing credentials: %w", err))
+  }
+  err = s.Auth.Authorized(client, legacy.Username, legacy.Secret)
+  if nil != err {
+    return x, Unauthorized(err)
+  }
+
+  // Substitute the body for the enclosed data
+  rq.Body = ioutil.NopCloser(bytes.NewReader(legacy.Data))
+  return user.ContextWithName(x, legacy.Username), nil
+}
+
+// Key resolves an API key to its owner, and the scopes of the key that the
+// owner's role still grants. Revoked keys, and keys of disabled users, are
+// refused. The time the key was last used is recorded, at most once per
+// KeyUseInterval
+func Key (s Service, x context.Context, token string) (auth.Identity, error) {
+  var (
+    id     int64         = 0
+    hash   []byte        = nil
+    salt   []byte        = nil
+    scopes string        = ""
+    role   string        = ""
+    z      auth.Identity = auth.Identity { Key: true }
+  )
+  prefix, secret, _ := auth.ParseAPIKey(token)
+  q := fmt.Sprintf("SELECT b.id, b.hash, b.salt, b.scopes, a.username, a.role " +
+                   "FROM %s AS a INNER JOIN %s AS b " +
+                   "ON a.id = b.user_id " +
+                   "WHERE b.prefix = ? AND NOT b.revoked AND NOT a.disabled",
+                   UserTable, APIKeyTable)
+  err := s.Database.DB.QueryRowContext(x, q, prefix).Scan(&id, &hash, &salt,
+    &scopes, &z.Username, &role)
+  if sql.ErrNoRows == err {
+    return auth.Identity{}, fmt.Errorf("No such API key")
+  } else if nil != err {
+    return auth.Identity{}, err
+  }
+  if !auth.Compare(secret, salt, hash) {
+    return auth.Identity{}, fmt.Errorf("API key secret mismatch")
+  }
+  z.Scopes = auth.Intersect(strings.Fields(scopes), auth.Permissions(role))
+
+  // Record use (unless recently recorded, here or by another process)
+  now := time.Now().UTC()
+  if last, ok := keysUsed.Get(id); ok && now.Sub(last) < KeyUseInterval {
+    return z, nil
+  }
+  q = fmt.Sprintf("UPDATE %s SET last_used = ? WHERE id = ? " +
+    "AND (last_used IS NULL OR last_used < ?)", APIKeyTable)
+  _, err = s.Database.DB.ExecContext(x, q, now, id, now.Add(-KeyUseInterval))
+  if nil != err {
+    return auth.Identity{}, err
+  }
+  keysUsed.Put(id, now)
+  return z, nil
+}
+
+// interactive returns true if the controller method refuses API keys
+func interactive (c Controller, method string) bool {
+  z, ok := c.(Interactive)
+  return ok && z.Interactive(method)
+}
+
+// Client returns the client making the request, as resolved ahead of the
+// controller
+func Client (x context.Context) auth.Client {
+  ip, _ := x.Value(user.UserIPKey).(string)
+  return auth.Client { IP: ip, Agent: user.Agent(x) }
+}
+
+// Authorize enforces the permissions the controller requires for the request
+// method. The permissions of the authenticated user are those carried by a
+// signed access token, or otherwise those granted by the user's role. They
+// are attached to the returned context, so that controllers may further
+// restrict access (e.g. to resources owned by the user)
+func Authorize (s Service, c Controller, x context.Context,
+  rq *http.Request, re *Result) (context.Context, error) {
+  var (
+    err         error    = nil
+    permissions []string = nil
+    required    []string = c.Permissions(rq.Method)
+  )
+
+  // Case: Not authenticated (permitted only if nothing is required)
+  username, ok := user.Name(x)
+  if !ok {
+    if 0 != len(required) {
+      return x, Unauthorized(fmt.Errorf("Not authenticated"))
+    }
+    return x, nil
+  }
+
+  // Case: Neither required nor of use to the controller
+  if 0 == len(required) && !c.Authenticated(rq.Method) {
+    return x, nil
+  }
+
+  // Resolve permissions from the role unless carried by the token
+  if permissions, ok = x.Value(user.UserPermissionsKey).([]string); !ok {
+    if permissions, err = Scopes(s, x, username); nil != err {
+      return x, Internal(err)
+    }
+    x = user.ContextWithPermissions(x, permissions)
+  }
+
+  if !auth.Permitted(permissions, required) {
+    return x, Forbidden(fmt.Errorf("Not permitted"))
+  }
+  return x, nil
+}
+
+// Scopes returns the permissions currently granted to the user by their role.
+// Disabled or unknown users are granted nothing
+func Scopes (s Service, x context.Context, username string) ([]string, error) {
+  var role string
+  q := fmt.Sprintf("SELECT role FROM %s WHERE username = ? AND NOT disabled",
+    UserTable)
+  err := s.Database.DB.QueryRowContext(x, q, username).Scan(&role)
+  if sql.ErrNoRows == err {
+    return []string{}, nil
+  } else if nil != err {
+    return nil, err
+  }
+  return auth.Permissions(role), nil
+}
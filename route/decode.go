@@ -0,0 +1,106 @@
+package route
+
+import (
+  "bytes"
+  "context"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "mime"
+  "net/http"
+)
+
+const (
+  DefaultBodyLimit = 1 << 20
+)
+
+
+/*\
+ *******************************************************************************
+ *                              Definition: Decode                             *
+ *******************************************************************************
+\*/
+
+
+// Limited: A controller accepting request bodies of another size than the
+// default (in bytes)
+type Limited interface {
+  BodyLimit() int64
+}
+
+// BodyLimit returns the largest request body the controller accepts
+func BodyLimit (c Controller) int64 {
+  if l, ok := c.(Limited); ok {
+    return l.BodyLimit()
+  }
+  return DefaultBodyLimit
+}
+
+// Bounded refuses request bodies larger than the controller accepts, as they
+// are read
+func Bounded (c Controller, next Method) Method {
+  limit := BodyLimit(c)
+  return func (r Restful, x context.Context, rq *http.Request, re *Result) error {
+    rq.Body = http.MaxBytesReader(nil, rq.Body, limit)
+    return next(r, x, rq, re)
+  }
+}
+
+// ReadBody reads the request body. A body too large is refused with 413
+func ReadBody (rq *http.Request) ([]byte, error) {
+  var tooLarge *http.MaxBytesError
+  body, err := ioutil.ReadAll(rq.Body)
+  if errors.As(err, &tooLarge) {
+    return nil, NewError(http.StatusRequestEntityTooLarge,
+      fmt.Errorf("Request body exceeds %d bytes", tooLarge.Limit))
+  } else if nil != err {
+    return nil, Internal(err)
+  }
+  return body, nil
+}
+
+// Decode reads the request body into the payload, and validates it (see
+// Validate). The body must be declared as JSON (415), must not exceed the
+// limit of the controller (413), and must hold exactly one JSON value with
+// no fields unknown to the payload (400)
+func Decode (rq *http.Request, v any) error {
+  return decode(rq, v, true)
+}
+
+// DecodeLenient is Decode, except that unknown fields are ignored. It is
+// meant for payloads defined elsewhere (e.g. WebAuthn credentials), which
+// may grow fields
+func DecodeLenient (rq *http.Request, v any) error {
+  return decode(rq, v, false)
+}
+
+func decode (rq *http.Request, v any, strict bool) error {
+  var trailing json.RawMessage
+
+  // Refuse what is not declared as JSON
+  mediaType, _, err := mime.ParseMediaType(rq.Header.Get(ContentTypeName))
+  if nil != err || ContentTypeJSON != mediaType {
+    return NewError(http.StatusUnsupportedMediaType,
+      fmt.Errorf("Content-Type must be %s", ContentTypeJSON))
+  }
+
+  body, err := ReadBody(rq)
+  if nil != err {
+    return err
+  }
+
+  d := json.NewDecoder(bytes.NewReader(body))
+  if strict {
+    d.DisallowUnknownFields()
+  }
+  if err = d.Decode(v); nil != err {
+    return BadRequest(err)
+  }
+  if err = d.Decode(&trailing); io.EOF != err {
+    return BadRequest(fmt.Errorf("Unexpected data after JSON value"))
+  }
+
+  return Validate(v)
+}
@@ -0,0 +1,119 @@
+package route
+
+import (
+  "context"
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "testing"
+)
+
+
+/*\
+ *******************************************************************************
+ *                                   Helpers                                   *
+ *******************************************************************************
+\*/
+
+
+// limitedController: Accepts request bodies of up to 16 bytes
+type limitedController struct {
+  testController
+}
+
+func (c *limitedController) BodyLimit () int64 {
+  return 16
+}
+
+// decodePayload: A payload with a required field
+type decodePayload struct {
+  Name string `json:"name" validate:"required"`
+}
+
+// newJSONRequest returns a request with the body, declared as the media type
+func newJSONRequest (contentType, body string) *http.Request {
+  rq := httptest.NewRequest(http.MethodPost, "/test", strings.NewReader(body))
+  if "" != contentType {
+    rq.Header.Set(ContentTypeName, contentType)
+  }
+  return rq
+}
+
+
+/*\
+ *******************************************************************************
+ *                                    Tests                                    *
+ *******************************************************************************
+\*/
+
+
+// TestDecode verifies that only a single JSON value, declared as JSON and
+// holding no unknown fields, is decoded, and that it is then validated
+func TestDecode (t *testing.T) {
+  cases := []struct {
+    ContentType string
+    Body        string
+    Status      int
+  } {
+    { ContentTypeJSON, `{"name":"a"}`, http.StatusOK },
+    { "application/json; charset=utf-8", `{"name":"a"}` + "\n", http.StatusOK },
+    { "", `{"name":"a"}`, http.StatusUnsupportedMediaType },
+    { ContentTypePlain, `{"name":"a"}`, http.StatusUnsupportedMediaType },
+    { "application/json;;", `{"name":"a"}`, http.StatusUnsupportedMediaType },
+    { ContentTypeJSON, `{"name":"a","other":1}`, http.StatusBadRequest },
+    { ContentTypeJSON, `{"name":"a"}{"name":"b"}`, http.StatusBadRequest },
+    { ContentTypeJSON, `{"name":"a"} x`, http.StatusBadRequest },
+    { ContentTypeJSON, `{"name":`, http.StatusBadRequest },
+    { ContentTypeJSON, ``, http.StatusBadRequest },
+    { ContentTypeJSON, `{"name":1}`, http.StatusBadRequest },
+    { ContentTypeJSON, `{}`, http.StatusUnprocessableEntity },
+  }
+  for _, c := range cases {
+    var v decodePayload
+    err := Decode(newJSONRequest(c.ContentType, c.Body), &v)
+    if status := Status(err); c.Status != status {
+      t.Fatalf("%q as %q gave %d (%v), expected %d", c.Body, c.ContentType,
+        status, err, c.Status)
+    }
+    if nil == err && "a" != v.Name {
+      t.Fatalf("%q decoded as %+v", c.Body, v)
+    }
+  }
+}
+
+// TestDecodeLenient verifies that unknown fields are ignored, but that the
+// body is otherwise held to the same rules
+func TestDecodeLenient (t *testing.T) {
+  var v decodePayload
+  rq := newJSONRequest(ContentTypeJSON, `{"name":"a","other":1}`)
+  if err := DecodeLenient(rq, &v); nil != err || "a" != v.Name {
+    t.Fatalf("Decoded as %+v (%v)", v, err)
+  }
+  rq = newJSONRequest(ContentTypeJSON, `{"name":"a"}{}`)
+  if err := DecodeLenient(rq, &v); http.StatusBadRequest != Status(err) {
+    t.Fatalf("Trailing data gave %v, expected 400", err)
+  }
+}
+
+// TestBounded verifies that bodies larger than the controller accepts are
+// refused as they are read
+func TestBounded (t *testing.T) {
+  c := &limitedController{}
+  m := Chain(c, func (r Restful, x context.Context, rq *http.Request,
+    re *Result) error {
+    var v decodePayload
+    return Decode(rq, &v)
+  }, Bounded)
+  for body, status := range map[string]int {
+    `{"name":"abcde"}`:  http.StatusOK,
+    `{"name":"abcdef"}`: http.StatusRequestEntityTooLarge,
+  } {
+    re, rq := DefaultResult(), newJSONRequest(ContentTypeJSON, body)
+    if err := m(c, rq.Context(), rq, &re); status != Status(err) {
+      t.Fatalf("%q gave %v, expected %d", body, err, status)
+    }
+  }
+  if limit := BodyLimit(&testController{}); DefaultBodyLimit != limit {
+    t.Fatalf("Default limit %d, expected %d", limit, DefaultBodyLimit)
+  }
+}
@@ -0,0 +1,190 @@
+package route
+
+import (
+  "context"
+  "encoding/json"
+  "encoding/xml"
+  "fmt"
+  "github.com/fxamacker/cbor/v2"
+  "github.com/vmihailenco/msgpack/v5"
+  "io"
+  "mime"
+  "net/http"
+  "strconv"
+  "strings"
+)
+
+
+/*\
+ *******************************************************************************
+ *                             Definition: Encoder                             *
+ *******************************************************************************
+\*/
+
+
+// Encoder: Writes response payloads as a media type. An encoder applying to
+// some payloads only (e.g. pages) says which through Encodes
+type Encoder struct {
+  MediaType string
+  Text      bool                       // Declares the charset (UTF-8)
+  Encode    func (io.Writer, any) error
+  Encodes   func (any) bool            // Applies to all payloads if nil
+}
+
+// Page: A payload with an HTML representation
+type Page interface {
+  HTML(io.Writer) error
+}
+
+// Feed: A payload with an XML representation (e.g. an RSS feed). The value
+// returned is encoded with encoding/xml
+type Feed interface {
+  Feed() any
+}
+
+// Encoders, in order of preference when the client has none
+var encoders []Encoder = []Encoder {
+  {
+    MediaType: ContentTypeJSON,
+    Encode:    func (w io.Writer, v any) error {
+      return json.NewEncoder(w).Encode(v)
+    },
+  },
+  {
+    MediaType: ContentTypeMsgPack,
+    Encode:    func (w io.Writer, v any) error {
+      e := msgpack.NewEncoder(w)
+      e.SetCustomStructTag("json")
+      return e.Encode(v)
+    },
+  },
+  {
+    MediaType: ContentTypeCBOR,
+    Encode:    func (w io.Writer, v any) error {
+      return cbor.NewEncoder(w).Encode(v)
+    },
+  },
+  {
+    MediaType: ContentTypeHTML,
+    Text:      true,
+    Encode:    func (w io.Writer, v any) error {
+      return v.(Page).HTML(w)
+    },
+    Encodes:   func (v any) bool {
+      _, ok := v.(Page)
+      return ok
+    },
+  },
+  {
+    MediaType: ContentTypeXML,
+    Text:      true,
+    Encode:    func (w io.Writer, v any) error {
+      if _, err := io.WriteString(w, xml.Header); nil != err {
+        return err
+      }
+      return xml.NewEncoder(w).Encode(v.(Feed).Feed())
+    },
+    Encodes:   func (v any) bool {
+      _, ok := v.(Feed)
+      return ok
+    },
+  },
+}
+
+// RegisterEncoder adds the encoder, or replaces that of the same media type.
+// Encoders must be registered before the server starts
+func RegisterEncoder (e Encoder) {
+  for i := range encoders {
+    if encoders[i].MediaType == e.MediaType {
+      encoders[i] = e
+      return
+    }
+  }
+  encoders = append(encoders, e)
+}
+
+// Negotiate returns the offered media type the Accept header prefers. Ties
+// go to the offer listed first, as does a missing header. It returns false
+// if no offer is acceptable
+func Negotiate (accept string, offers []string) (string, bool) {
+  var (
+    best    string  = ""
+    quality float64 = 0
+  )
+  if "" == strings.TrimSpace(accept) && 0 != len(offers) {
+    return offers[0], true
+  }
+  for _, offer := range offers {
+    if q := acceptQuality(accept, offer); q > quality {
+      best, quality = offer, q
+    }
+  }
+  return best, 0 != quality
+}
+
+// acceptQuality returns the quality the Accept header gives the media type,
+// as set by the most specific range matching it
+func acceptQuality (accept, mediaType string) float64 {
+  var (
+    quality     float64 = 0
+    specificity int     = -1
+  )
+  kind, _, _ := strings.Cut(mediaType, "/")
+  for _, value := range strings.Split(accept, ",") {
+    name, params, err := mime.ParseMediaType(strings.TrimSpace(value))
+    if nil != err {
+      continue
+    }
+
+    // Rank the range: exact, type wildcard, or full wildcard
+    s := -1
+    switch name {
+    case mediaType:
+      s = 2
+    case kind + "/*":
+      s = 1
+    case "*/*":
+      s = 0
+    }
+    if s <= specificity {
+      continue
+    }
+
+    specificity, quality = s, 1
+    if q, err := strconv.ParseFloat(params["q"], 64); nil == err &&
+      q >= 0 && q <= 1 {
+      quality = q
+    }
+  }
+  return quality
+}
+
+// Acceptable refuses requests accepting none of the registered encoders
+// (406), ahead of the controller. The Accept header and method are kept on
+// the result, for Marshal
+func Acceptable (c Controller, next Method) Method {
+  return func (r Restful, x context.Context, rq *http.Request, re *Result) error {
+    re.Accept, re.Method = rq.Header.Get(AcceptName), rq.Method
+    offers := []string{}
+    for _, e := range encoders {
+      offers = append(offers, e.MediaType)
+    }
+    if _, ok := Negotiate(re.Accept, offers); !ok {
+      return notAcceptable(offers)
+    }
+    return next(r, x, rq, re)
+  }
+}
+
+// notAcceptable returns the error for a request accepting none of the offers
+func notAcceptable (offers []string) error {
+  return NotAcceptable(
+    fmt.Errorf("Acceptable media types are %s", strings.Join(offers, ", ")))
+}
+
+// safe returns true if the method only retrieves (RFC 9110, 9.2.1), so that
+// refusing its response after the controller ran loses nothing
+func safe (method string) bool {
+  return http.MethodGet == method || http.MethodHead == method ||
+    http.MethodOptions == method
+}
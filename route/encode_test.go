@@ -0,0 +1,114 @@
+package route
+
+import (
+  "net/http"
+  "testing"
+)
+
+
+/*\
+ *******************************************************************************
+ *                                    Tests                                    *
+ *******************************************************************************
+\*/
+
+
+// TestAcceptQuality verifies that the most specific range matching a media
+// type sets its quality, and that malformed qualities count as 1
+func TestAcceptQuality (t *testing.T) {
+  cases := []struct {
+    Accept    string
+    MediaType string
+    Quality   float64
+  } {
+    { "application/json", ContentTypeJSON, 1 },
+    { "application/json;q=0.5", ContentTypeJSON, 0.5 },
+    { "application/*;q=0.3, application/json;q=0.7", ContentTypeJSON, 0.7 },
+    { "application/json;q=0.7, application/*;q=0.3", ContentTypeJSON, 0.7 },
+    { "*/*;q=0.1, application/*;q=0.4", ContentTypeCBOR, 0.4 },
+    { "*/*;q=0.1, application/*;q=0.4", ContentTypeHTML, 0.1 },
+    { "application/json;q=0, */*", ContentTypeJSON, 0 },
+    { "text/html", ContentTypeJSON, 0 },
+    { "application/json;q=2", ContentTypeJSON, 1 },
+    { "application/json;q=-1", ContentTypeJSON, 1 },
+    { "application/json;q=high", ContentTypeJSON, 1 },
+    { "application/json;;", ContentTypeJSON, 0 },
+    { "", ContentTypeJSON, 0 },
+  }
+  for _, c := range cases {
+    if q := acceptQuality(c.Accept, c.MediaType); c.Quality != q {
+      t.Fatalf("%q gave %s quality %v, expected %v", c.Accept, c.MediaType, q,
+        c.Quality)
+    }
+  }
+}
+
+// TestNegotiate verifies the offer chosen, ties going to the first
+func TestNegotiate (t *testing.T) {
+  offers := []string{ ContentTypeJSON, ContentTypeCBOR, ContentTypeHTML }
+  cases := []struct {
+    Accept    string
+    MediaType string
+    Ok        bool
+  } {
+    { "", ContentTypeJSON, true },
+    { "  ", ContentTypeJSON, true },
+    { "*/*", ContentTypeJSON, true },
+    { "application/*", ContentTypeJSON, true },
+    { "application/cbor", ContentTypeCBOR, true },
+    { "application/json;q=0.5, application/cbor", ContentTypeCBOR, true },
+    { "text/html, application/json", ContentTypeJSON, true },
+    { "*/*;q=0.1, text/*", ContentTypeHTML, true },
+    { "application/json;q=0, */*", ContentTypeCBOR, true },
+    { "text/plain", "", false },
+    { "*/*;q=0", "", false },
+  }
+  for _, c := range cases {
+    mediaType, ok := Negotiate(c.Accept, offers)
+    if c.MediaType != mediaType || c.Ok != ok {
+      t.Fatalf("%q gave %s (%v), expected %s (%v)", c.Accept, mediaType, ok,
+        c.MediaType, c.Ok)
+    }
+  }
+  if _, ok := Negotiate("", nil); ok {
+    t.Fatalf("No offers accepted")
+  }
+}
+
+// TestMarshal verifies that payloads are encoded as negotiated, and that
+// clients accepting none of the encoders applying to a payload are refused
+// for safe methods, and answered in JSON otherwise
+func TestMarshal (t *testing.T) {
+  cases := []struct {
+    Method      string
+    Accept      string
+    ContentType string
+  } {
+    { http.MethodGet, "", ContentTypeJSON },
+    { http.MethodGet, ContentTypeCBOR, ContentTypeCBOR },
+    { http.MethodGet, ContentTypeHTML, "" },
+    { http.MethodHead, ContentTypeXML, "" },
+    { http.MethodPost, ContentTypeCBOR, ContentTypeCBOR },
+    { http.MethodPost, ContentTypeHTML, ContentTypeJSON },
+    { http.MethodPut, ContentTypeXML, ContentTypeJSON },
+    { http.MethodDelete, ContentTypePlain, ContentTypeJSON },
+  }
+  for _, c := range cases {
+    re := DefaultResult()
+    re.Accept, re.Method = c.Accept, c.Method
+    err := re.Marshal(map[string]string{ "key": "secret" })
+    if "" == c.ContentType {
+      if http.StatusNotAcceptable != Status(err) {
+        t.Fatalf("%s with %q gave %v, expected 406", c.Method, c.Accept, err)
+      }
+      continue
+    }
+    if nil != err {
+      t.Fatalf("%s with %q failed: %v", c.Method, c.Accept, err)
+    }
+    if c.ContentType != re.ContentType || 0 == re.Buffer.Len() {
+      t.Fatalf("%s with %q gave %s, expected %s", c.Method, c.Accept,
+        re.ContentType, c.ContentType)
+    }
+  }
+}
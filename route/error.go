@@ -0,0 +1,165 @@
+package route
+
+import (
+  "context"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "net/http"
+  "slices"
+  "strings"
+)
+
+
+/*\
+ *******************************************************************************
+ *                               Definition: Error                             *
+ *******************************************************************************
+\*/
+
+
+// Error: An error with the status it is answered with. Field errors name the
+// fields of the request failing validation, and why
+type Error struct {
+  Status int
+  Err    error
+  Fields map[string]string
+}
+
+func (e *Error) Error () string {
+  return e.Err.Error()
+}
+
+func (e *Error) Unwrap () error {
+  return e.Err
+}
+
+// Details returns the field errors, if any
+func (e *Error) Details () any {
+  if 0 == len(e.Fields) {
+    return nil
+  }
+  return e.Fields
+}
+
+// NewError returns an error answered with the status
+func NewError (status int, err error) error {
+  return &Error { Status: status, Err: err }
+}
+
+func BadRequest (err error) error {
+  return NewError(http.StatusBadRequest, err)
+}
+
+func Unauthorized (err error) error {
+  return NewError(http.StatusUnauthorized, err)
+}
+
+func Forbidden (err error) error {
+  return NewError(http.StatusForbidden, err)
+}
+
+func NotFound (err error) error {
+  return NewError(http.StatusNotFound, err)
+}
+
+func NotAcceptable (err error) error {
+  return NewError(http.StatusNotAcceptable, err)
+}
+
+func Conflict (err error) error {
+  return NewError(http.StatusConflict, err)
+}
+
+func TooManyRequests (err error) error {
+  return NewError(http.StatusTooManyRequests, err)
+}
+
+func Unavailable (err error) error {
+  return NewError(http.StatusServiceUnavailable, err)
+}
+
+func Internal (err error) error {
+  return NewError(http.StatusInternalServerError, err)
+}
+
+// Validation returns an error naming the fields failing validation
+func Validation (fields map[string]string) error {
+  names := make([]string, 0, len(fields))
+  for name := range fields {
+    names = append(names, name)
+  }
+  slices.Sort(names)
+  return &Error {
+    Status: http.StatusUnprocessableEntity,
+    Err:    fmt.Errorf("Invalid %s", strings.Join(names, ", ")),
+    Fields: fields,
+  }
+}
+
+// Status returns the status the error is answered with. Errors of no type
+// (or unknown type) are internal server errors, except for cancellation
+func Status (err error) int {
+  var e *Error
+  switch {
+  case nil == err:
+    return http.StatusOK
+  case errors.As(err, &e):
+    return e.Status
+  case errors.Is(err, context.DeadlineExceeded),
+       errors.Is(err, context.Canceled):
+    return http.StatusServiceUnavailable
+  }
+  return http.StatusInternalServerError
+}
+
+
+/*\
+ *******************************************************************************
+ *                          Definition: Error Response                         *
+ *******************************************************************************
+\*/
+
+
+// Detailed: An error with details for the client (e.g. the fields failing
+// validation), included in the error response
+type Detailed interface {
+  Details() any
+}
+
+// ErrorResponse: The body of every error response. The code names the
+// status (e.g. "not_found"), and the request ID refers to the server log
+type ErrorResponse struct {
+  Code      string `json:"code"`
+  Message   string `json:"message"`
+  Details   any    `json:"details,omitempty"`
+  RequestID string `json:"request_id,omitempty"`
+}
+
+// Code returns the code naming the status
+func Code (status int) string {
+  return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+// NewErrorResponse returns the error response for the error and status
+func NewErrorResponse (err error, status int, id string) ErrorResponse {
+  var d Detailed
+  z := ErrorResponse {
+    Code:      Code(status),
+    Message:   err.Error(),
+    RequestID: id,
+  }
+  if errors.As(err, &d) {
+    z.Details = d.Details()
+  }
+  return z
+}
+
+// WriteError writes the error response, with the status the error maps to
+func WriteError (w http.ResponseWriter, err error, id string) {
+  status := Status(err)
+  w.Header().Set(ContentTypeName, ContentTypeJSON)
+  w.Header().Set("X-Content-Type-Options", "nosniff")
+  w.WriteHeader(status)
+  json.NewEncoder(w).Encode(NewErrorResponse(err, status, id))
+}
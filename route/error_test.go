@@ -0,0 +1,117 @@
+package route
+
+import (
+  "context"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "net/http"
+  "net/http/httptest"
+  "testing"
+)
+
+
+/*\
+ *******************************************************************************
+ *                                    Tests                                    *
+ *******************************************************************************
+\*/
+
+
+// TestCode verifies the codes naming statuses
+func TestCode (t *testing.T) {
+  for status, code := range map[int]string {
+    http.StatusNotFound:              "not_found",
+    http.StatusTooManyRequests:       "too_many_requests",
+    http.StatusUnprocessableEntity:   "unprocessable_entity",
+    http.StatusRequestEntityTooLarge: "request_entity_too_large",
+    http.StatusInternalServerError:   "internal_server_error",
+  } {
+    if c := Code(status); code != c {
+      t.Fatalf("Status %d has code %q, expected %q", status, c, code)
+    }
+  }
+}
+
+// TestWriteError verifies that errors are answered with their status, as a
+// JSON body carrying the code, message, details and request ID
+func TestWriteError (t *testing.T) {
+  var z ErrorResponse
+  w := httptest.NewRecorder()
+  WriteError(w, Validation(map[string]string{ "name": "is required" }), "id")
+  if http.StatusUnprocessableEntity != w.Code ||
+    ContentTypeJSON != w.Header().Get(ContentTypeName) {
+    t.Fatalf("Written with %d, %s", w.Code, w.Header().Get(ContentTypeName))
+  }
+  if err := json.Unmarshal(w.Body.Bytes(), &z); nil != err {
+    t.Fatalf("Unmarshal failed: %v", err)
+  }
+  details, _ := z.Details.(map[string]any)
+  if "unprocessable_entity" != z.Code || "Invalid name" != z.Message ||
+    "id" != z.RequestID || "is required" != details["name"] {
+    t.Fatalf("Written as %+v", z)
+  }
+
+  // Errors of no type, without details
+  w = httptest.NewRecorder()
+  WriteError(w, errors.New("Failed"), "")
+  if http.StatusInternalServerError != w.Code {
+    t.Fatalf("Written with %d, expected 500", w.Code)
+  }
+  if body := w.Body.String(); "{\"code\":\"internal_server_error\"," +
+    "\"message\":\"Failed\"}\n" != body {
+    t.Fatalf("Written as %s", body)
+  }
+}
+
+// TestStatus verifies the statuses errors are answered with, through
+// wrapping, and that cancelled requests are unavailable
+func TestStatus (t *testing.T) {
+  failed := errors.New("Failed")
+  cases := []struct {
+    Err    error
+    Status int
+  } {
+    { nil, http.StatusOK },
+    { failed, http.StatusInternalServerError },
+    { BadRequest(failed), http.StatusBadRequest },
+    { Unauthorized(failed), http.StatusUnauthorized },
+    { Forbidden(failed), http.StatusForbidden },
+    { NotFound(failed), http.StatusNotFound },
+    { NotAcceptable(failed), http.StatusNotAcceptable },
+    { Conflict(failed), http.StatusConflict },
+    { TooManyRequests(failed), http.StatusTooManyRequests },
+    { Unavailable(failed), http.StatusServiceUnavailable },
+    { Internal(failed), http.StatusInternalServerError },
+    { Validation(nil), http.StatusUnprocessableEntity },
+    { NewError(http.StatusTeapot, failed), http.StatusTeapot },
+    { fmt.Errorf("Wrapped: %w", NotFound(failed)), http.StatusNotFound },
+    { context.DeadlineExceeded, http.StatusServiceUnavailable },
+    { fmt.Errorf("Query: %w", context.Canceled),
+      http.StatusServiceUnavailable },
+    { Internal(context.Canceled), http.StatusInternalServerError },
+  }
+  for _, c := range cases {
+    if status := Status(c.Err); c.Status != status {
+      t.Fatalf("Error %v answered with %d, expected %d", c.Err, status,
+        c.Status)
+    }
+  }
+}
+
+// TestError verifies that typed errors keep the error they wrap, and only
+// carry details if fields failed validation
+func TestError (t *testing.T) {
+  failed := errors.New("Failed")
+  err := NotFound(failed)
+  if !errors.Is(err, failed) || "Failed" != err.Error() {
+    t.Fatalf("Error %v does not wrap %v", err, failed)
+  }
+  if z := NewErrorResponse(err, Status(err), ""); nil != z.Details {
+    t.Fatalf("Error without fields has details %v", z.Details)
+  }
+  err = Validation(map[string]string{ "b": "is required", "a": "is bad" })
+  if "Invalid a, b" != err.Error() {
+    t.Fatalf("Validation error %q, expected the fields in order", err)
+  }
+}
@@ -2,20 +2,21 @@ package login
 
 import (
   "context"
-  "encoding/json"
+  "database/sql"
   "fmt"
-  "io/ioutil"
+  "math"
   "micrified.com/internal/user"
   "micrified.com/route"
   "micrified.com/service/auth"
   "net/http"
+  "strconv"
   "time"
 )
 
 
 // Data: Login
 type loginData struct {
-  TimeFormat, UserTable, CredentialTable string
+  TimeFormat, UserTable, CredentialTable, TOTPTable, RecoveryTable string
 }
 
 // Controller: Login
@@ -34,13 +35,18 @@ func NewController (s route.Service) Controller {
     Name:             "login",
     Methods: map[string]route.Method {
       http.MethodPost: route.Restful.Post,
+      http.MethodPut:  route.Restful.Put,
     },
+    Secured:           map[string]bool{},
+    Access:            map[string][]string{},
     Service:           s,
     Limit:             5 * time.Second,
     Data: loginData {
       TimeFormat:      "2006-01-02 15:04:05",
       UserTable:       "users",
       CredentialTable: "credentials",
+      TOTPTable:       "totp",
+      RecoveryTable:   "recovery_codes",
     },
   }
 }
@@ -60,6 +66,14 @@ func (c *Controller) Timeout () time.Duration {
   return c.Limit
 }
 
+func (c *Controller) Authenticated (s string) bool {
+  return c.Secured[s]
+}
+
+func (c *Controller) Permissions (s string) []string {
+  return c.Access[s]
+}
+
 
 /*\
  *******************************************************************************
@@ -73,101 +87,472 @@ func (c *Controller) Get (x context.Context, rq *http.Request, re *route.Result)
 }
 
 type LoginCredential struct {
-  Username        string `json:"userid"`
+  Username        string `json:"userid"     validate:"max=255"`
   Passphrase      string `json:"passphrase"`
-  Period          string `json:"period"`
+  Period          string `json:"period"     validate:"numeric"`
+  Challenge       string `json:"challenge"`
+  Code            string `json:"code"`
+}
+
+// Validate: A challenge is answered with a code. Blank credentials are not
+// refused here, but fail (and are penalised) like any other bad credentials,
+// so that they cannot be used to probe without being throttled
+func (l *LoginCredential) Validate () map[string]string {
+  fields := map[string]string{}
+  if "" != l.Challenge && "" == l.Code {
+    fields["code"] = "is required"
+  }
+  return fields
 }
 
 type StoredCredential struct {
   Hash, Salt []byte
 }
 
+// SessionCredential: The session expires when idle beyond the expiration,
+// or at the deadline at the latest
 type SessionCredential struct {
   Secret     string `json:"secret"`
   Expiration string `json:"expiration"`
+  Deadline   string `json:"deadline"`
+}
+
+type ChallengeCredential struct {
+  Challenge  string `json:"challenge"`
+  Expiration string `json:"expiration"`
+}
+
+type TokenCredential struct {
+  Access     string `json:"access"`
+  Refresh    string `json:"refresh"`
+  Expiration string `json:"expiration"`
+  Renewal    string `json:"renewal"`
 }
 
 func (c *Controller) Post (x context.Context, rq *http.Request, re *route.Result) error {
   var (
-    body    []byte              = []byte{}
     err     error               = nil
     ip      string              = x.Value(user.UserIPKey).(string)
     login   LoginCredential     = LoginCredential{}
   )
 
-  fail := func (err error, status int) error {
-    re.Status = status
+  // Decode and validate request body
+  if err = route.Decode(rq, &login); nil != err {
     return err
   }
 
-  // Read request body
-  if body, err = ioutil.ReadAll(rq.Body); nil != err {
-    return fail(err, http.StatusInternalServerError)
+  // Check if a retry penalty exists for the IP or account
+  if err = c.Throttle(x, ip, login.Username, route.ActionLogin,
+    re); nil != err {
+    return err
   }
 
-  // Unmarshal to type
-  if err = json.Unmarshal(body, &login); nil != err {
-    return fail(err, http.StatusBadRequest)
+  // Define the authentication routine
+  doAuth := c.passphrase(x, login.Username, login.Passphrase)
+
+  // Case: Second login step (answering a challenge)
+  if "" != login.Challenge {
+    return c.answer(x, &login, re)
+  }
+
+  // Check whether a second factor is required
+  twoFactor, err := c.twoFactor(x, login.Username)
+  if nil != err {
+    return route.Internal(err)
   }
 
-  // Check if a retry penalty exists (IP must exist)
-  if c.Service.Auth.Penalised(ip) {
-    return fail(fmt.Errorf("Try again later"), http.StatusTooManyRequests)
+  // Case: First login step; the second factor is challenged for
+  if twoFactor {
+    token, z, ok, err := c.Service.Auth.Challenge(ip, login.Username,
+      login.Period, doAuth)
+    if nil != err {
+      return route.Internal(err)
+    }
+    if !ok {
+      c.Service.Auth.Penalise(x, ip)
+      c.Service.Auth.PenaliseAccount(x, login.Username)
+      route.Audit(c.Service, x, route.Event {
+        Actor: login.Username, IP: ip, Action: route.ActionLogin,
+        Outcome: route.OutcomeFailure,
+      })
+      return route.Unauthorized(fmt.Errorf("Bad credentials"))
+    }
+    re.Status = http.StatusAccepted
+    return re.Marshal(
+      &ChallengeCredential {
+        Challenge:  token,
+        Expiration: z.Expiration.Format(c.Data.TimeFormat),
+      })
   }
 
+  return c.Establish(x, login.Username, login.Period, doAuth, re)
+}
+
+// passphrase returns the routine authenticating the user by passphrase
+func (c *Controller) passphrase (x context.Context, username,
+  passphrase string) auth.AuthFunc {
+
   // Extract stored login credentials
   q := fmt.Sprintf("SELECT b.hash, b.salt " +
                    "FROM %s AS a INNER JOIN %s AS b " +
 		   "ON a.id = b.user_id " +
-		   "WHERE a.username = ?", 
+		   "WHERE a.username = ? AND NOT a.disabled", 
 		   c.Data.UserTable, c.Data.CredentialTable)
 
-  // Define the authentication routine
-  doAuth := func () (bool, error) {
+  return func () (bool, error) {
     var stored StoredCredential
 
-    rows, err := c.Service.Database.DB.Query(q, login.Username)
+    rows, err := c.Service.Database.DB.QueryContext(x, q, username)
     if nil != err {
       return false, err
     }
+    defer rows.Close()
     if !rows.Next() { // No error implies non-infrastructure related error
-      fmt.Println("No account")
       return false, nil
     }
     if err = rows.Scan(&stored.Hash, &stored.Salt); nil != err {
       return false, err
     }
-    fmt.Println("Comparing credentials ...")
-    return auth.Compare(login.Passphrase, stored.Salt, stored.Hash), nil
+    return auth.Compare(passphrase, stored.Salt, stored.Hash), nil
+  }
+}
+
+// Throttle refuses the request while the IP or the account is penalised,
+// telling the client when it may retry. Refusals are audited as denied
+// attempts of the action. It returns nil otherwise. Every login path checks
+// it before authenticating
+func (c *Controller) Throttle (x context.Context, ip, username, action string,
+  re *route.Result) error {
+  wait := c.Service.Auth.RetryAfter(x, ip, username)
+  if wait <= 0 {
+    return nil
+  }
+  route.Audit(c.Service, x, route.Event {
+    Actor: username, IP: ip, Action: action, Outcome: route.OutcomeDenied,
+  })
+  re.Header.Set(route.RetryAfterName,
+    strconv.FormatInt(int64(math.Ceil(wait.Seconds())), 10))
+  return route.TooManyRequests(fmt.Errorf("Try again later"))
+}
+
+// twoFactor returns true if the user has enabled a second factor
+func (c *Controller) twoFactor (x context.Context, username string) (bool, error) {
+  var enabled bool
+  q := fmt.Sprintf("SELECT b.enabled " +
+                   "FROM %s AS a INNER JOIN %s AS b " +
+                   "ON a.id = b.user_id " +
+                   "WHERE a.username = ?",
+                   c.Data.UserTable, c.Data.TOTPTable)
+  err := c.Service.Database.DB.QueryRowContext(x, q, username).Scan(&enabled)
+  if sql.ErrNoRows == err {
+    return false, nil
+  }
+  return enabled, err
+}
+
+// answer verifies the code given for a pending challenge (see secondFactor).
+// Wrong codes are penalised in the same way as bad passphrases
+func (c *Controller) answer (x context.Context, login *LoginCredential,
+  re *route.Result) error {
+  var ip string = route.Client(x).IP
+
+  z, err := c.Service.Auth.Pending(ip, login.Challenge)
+  if nil != err {
+    c.Service.Auth.Penalise(x, ip)
+    return route.Unauthorized(err)
+  }
+
+  // Check if a retry penalty exists for the account being logged into
+  if err = c.Throttle(x, ip, z.Username, route.ActionLogin, re); nil != err {
+    return err
+  }
+
+  err = c.Establish(x, z.Username, z.Period,
+    c.secondFactor(x, z.Username, login.Code), re)
+  if nil == err {
+    c.Service.Auth.Answered(login.Challenge)
+  }
+  return err
+}
+
+// secondFactor returns the routine verifying the code of the user: either the
+// current one-time password, or an unused recovery code (which is then spent)
+func (c *Controller) secondFactor (x context.Context, username,
+  code string) auth.AuthFunc {
+  return func () (bool, error) {
+    var (
+      now    time.Time = time.Now().UTC()
+      secret string    = ""
+      last   int64     = 0
+      id     int64     = 0
+    )
+
+    q := fmt.Sprintf("SELECT a.id, b.secret, b.last_step " +
+                     "FROM %s AS a INNER JOIN %s AS b " +
+                     "ON a.id = b.user_id " +
+                     "WHERE a.username = ? AND b.enabled " +
+                     "AND NOT a.disabled",
+                     c.Data.UserTable, c.Data.TOTPTable)
+    err := c.Service.Database.DB.QueryRowContext(x, q, username).Scan(&id,
+      &secret, &last)
+    if sql.ErrNoRows == err {
+      return false, nil
+    } else if nil != err {
+      return false, err
+    }
+
+    // Case: One-time password (consumes the time step)
+    if step, ok := auth.VerifyTOTP(secret, code, now, last); ok {
+      q = fmt.Sprintf("UPDATE %s SET last_step = ? " +
+                      "WHERE user_id = ? AND last_step < ?", c.Data.TOTPTable)
+      r, err := c.Service.Database.DB.ExecContext(x, q, step, id, step)
+      if nil != err {
+        return false, err
+      }
+      n, err := r.RowsAffected()
+      return 1 == n, err
+    }
+
+    // Case: Recovery code
+    q = fmt.Sprintf("SELECT id, hash, salt FROM %s " +
+                    "WHERE user_id = ? AND NOT used", c.Data.RecoveryTable)
+    rows, err := c.Service.Database.DB.QueryContext(x, q, id)
+    if nil != err {
+      return false, err
+    }
+    defer rows.Close()
+    for rows.Next() {
+      var (
+        recovery int64
+        stored   StoredCredential
+      )
+      if err = rows.Scan(&recovery, &stored.Hash, &stored.Salt); nil != err {
+        return false, err
+      }
+      if !auth.Compare(code, stored.Salt, stored.Hash) {
+        continue
+      }
+      q = fmt.Sprintf("UPDATE %s SET used = TRUE WHERE id = ? AND NOT used",
+        c.Data.RecoveryTable)
+      r, err := c.Service.Database.DB.ExecContext(x, q, recovery)
+      if nil != err {
+        return false, err
+      }
+      n, err := r.RowsAffected()
+      return 1 == n, err
+    }
+    return false, rows.Err()
   }
+}
 
-  // Perform authentication 
-  session, ok, err := c.Service.Auth.Authenticate(ip, login.Username, 
-    login.Period, doAuth)
+// Establish authenticates the user with the given routine, and composes the
+// response: a session bound to the client, or a token grant if stateless
+func (c *Controller) Establish (x context.Context, username, period string,
+  f auth.AuthFunc, re *route.Result) error {
+  var (
+    client  auth.Client  = route.Client(x)
+    err     error        = nil
+    grant   auth.Grant   = auth.Grant{}
+    ok      bool         = false
+    session auth.Session = auth.Session{}
+  )
+
+  // Perform authentication (granting signed tokens if stateless)
+  if c.Service.Auth.Stateless() {
+    var scopes []string
+    if scopes, err = route.Scopes(c.Service, x, username); nil != err {
+      return route.Internal(err)
+    }
+    grant, ok, err = c.Service.Auth.Grant(x, username, scopes, f)
+  } else {
+    session, ok, err = c.Service.Auth.Authenticate(client, username,
+      period, f)
+  }
   if err != nil {
     // TODO: Don't leak info here
-    return fail(err, http.StatusInternalServerError)
+    return route.Internal(err)
   }
 
-  // Wipe penalty and create session if OK; else penalise and return error
+  // Wipe penalties and create session if OK; else penalise and return error
+  event := route.Event {
+    Actor: username, IP: client.IP, Action: route.ActionLogin,
+    Outcome: route.OutcomeSuccess,
+  }
   if ok {
-    c.Service.Auth.NoPenalty(ip)
+    c.Service.Auth.NoPenalty(x, client.IP)
+    c.Service.Auth.NoAccountPenalty(x, username)
+    route.Audit(c.Service, x, event)
   } else {
-    c.Service.Auth.Penalise(ip)
-    return fail(fmt.Errorf("Bad credentials"), http.StatusUnauthorized)
+    c.Service.Auth.Penalise(x, client.IP)
+    c.Service.Auth.PenaliseAccount(x, username)
+    event.Outcome = route.OutcomeFailure
+    route.Audit(c.Service, x, event)
+    return route.Unauthorized(fmt.Errorf("Bad credentials"))
+  }
+
+  // Compose token response
+  if c.Service.Auth.Stateless() {
+    return re.Marshal(NewTokenCredential(&grant, c.Data.TimeFormat))
+  }
+
+  // Install the session cookie, if in use
+  if name := c.Service.Auth.Cookie(); "" != name {
+    re.Header.Add("Set-Cookie", (&http.Cookie {
+      Name:     name,
+      Value:    session.Secret.HexString(),
+      Path:     "/",
+      HttpOnly: true,
+      Secure:   true,
+      SameSite: http.SameSiteStrictMode,
+    }).String())
   }
 
   // Compose response
-  fmt.Printf("Response should be OK: %+v\n", re)
-  return re.Marshal(route.ContentTypeJSON,
+  return re.Marshal(
     &SessionCredential {
       Secret:      session.Secret.HexString(),
       Expiration:  session.Expiration.Format(c.Data.TimeFormat),
+      Deadline:    session.Deadline.Format(c.Data.TimeFormat),
   })
 }
 
+// NewTokenCredential returns the response form of a token grant
+func NewTokenCredential (g *auth.Grant, timeFormat string) *TokenCredential {
+  return &TokenCredential {
+    Access:     g.Access,
+    Refresh:    g.Refresh,
+    Expiration: g.Expiration.Format(timeFormat),
+    Renewal:    g.Renewal.Format(timeFormat),
+  }
+}
+
+type RebindCredential struct {
+  Passphrase string `json:"passphrase"`
+  Challenge  string `json:"challenge"`
+  Code       string `json:"code"`
+}
+
+// Validate: A challenge is answered with a code; otherwise the owner
+// re-authenticates with a passphrase
+func (r *RebindCredential) Validate () map[string]string {
+  fields := map[string]string{}
+  if "" != r.Challenge && "" == r.Code {
+    fields["code"] = "is required"
+  }
+  if "" == r.Challenge && "" == r.Passphrase {
+    fields["passphrase"] = "is required"
+  }
+  return fields
+}
+
+// Put re-authenticates the holder of a session bound to another client (see
+// auth.ErrRebind), binding the session to the requesting client. The session
+// is presented as for any authenticated request, alongside the passphrase of
+// its owner. If the owner has enabled a second factor, a challenge is issued
+// instead, and answered in a second request as for Post. Failures are
+// penalised in the same way as bad logins
 func (c *Controller) Put (x context.Context, rq *http.Request, re *route.Result) error {
-  return re.Unimplemented()
+  var (
+    client  auth.Client      = route.Client(x)
+    err     error            = nil
+    rebind  RebindCredential = RebindCredential{}
+    session auth.Session     = auth.Session{}
+    ok      bool             = false
+  )
+
+  // Case: Sessions are never rebound
+  if !c.Service.Auth.Rebinding() || c.Service.Auth.Stateless() {
+    return re.Unimplemented()
+  }
+
+  // Decode and validate request body
+  if err = route.Decode(rq, &rebind); nil != err {
+    return err
+  }
+
+  // Find the session presented
+  token, ok := user.RequestToken(rq, c.Service.Auth.Cookie())
+  if !ok {
+    return route.Unauthorized(fmt.Errorf("Missing session"))
+  }
+  username, _ := c.Service.Auth.Owner(token)
+
+  // Check if a retry penalty exists for the IP or account
+  if err = c.Throttle(x, client.IP, username,
+    route.ActionRebind, re); nil != err {
+    return err
+  }
+
+  // Define the re-authentication routine
+  event := route.Event {
+    Actor: username, IP: client.IP, Action: route.ActionRebind,
+    Outcome: route.OutcomeFailure,
+  }
+  doAuth := c.passphrase(x, username, rebind.Passphrase)
+  if "" != rebind.Challenge {
+
+    // Case: Second step (answering a challenge issued to the owner)
+    z, err := c.Service.Auth.Pending(client.IP, rebind.Challenge)
+    if nil == err && z.Username != username {
+      err = fmt.Errorf("Challenge owner mismatch")
+    }
+    if nil != err {
+      c.Service.Auth.Penalise(x, client.IP)
+      route.Audit(c.Service, x, event)
+      return route.Unauthorized(err)
+    }
+    doAuth = c.secondFactor(x, username, rebind.Code)
+  } else if twoFactor, err := c.twoFactor(x, username); nil != err {
+    return route.Internal(err)
+  } else if twoFactor {
+
+    // Case: First step; the second factor is challenged for
+    challenge, z, ok, err := c.Service.Auth.Challenge(client.IP, username,
+      "", doAuth)
+    if nil != err {
+      return route.Internal(err)
+    }
+    if !ok {
+      c.Service.Auth.Penalise(x, client.IP)
+      c.Service.Auth.PenaliseAccount(x, username)
+      route.Audit(c.Service, x, event)
+      return route.Unauthorized(fmt.Errorf("Bad credentials"))
+    }
+    re.Status = http.StatusAccepted
+    return re.Marshal(
+      &ChallengeCredential {
+        Challenge:  challenge,
+        Expiration: z.Expiration.Format(c.Data.TimeFormat),
+      })
+  }
+
+  // Re-authenticate; penalise failures
+  session, ok, err = c.Service.Auth.Rebind(client, username, token, doAuth)
+  if nil != err {
+    return route.Internal(err)
+  }
+  if !ok {
+    c.Service.Auth.Penalise(x, client.IP)
+    if "" != username {
+      c.Service.Auth.PenaliseAccount(x, username)
+    }
+    route.Audit(c.Service, x, event)
+    return route.Unauthorized(fmt.Errorf("Bad credentials"))
+  }
+  if "" != rebind.Challenge {
+    c.Service.Auth.Answered(rebind.Challenge)
+  }
+  c.Service.Auth.NoPenalty(x, client.IP)
+  c.Service.Auth.NoAccountPenalty(x, username)
+  event.Outcome = route.OutcomeSuccess
+  route.Audit(c.Service, x, event)
+
+  return re.Marshal(
+    &SessionCredential {
+      Secret:     session.Secret.HexString(),
+      Expiration: session.Expiration.Format(c.Data.TimeFormat),
+      Deadline:   session.Deadline.Format(c.Data.TimeFormat),
+    })
 }
 
 func (c *Controller) Delete (x context.Context, rq *http.Request, re *route.Result) error {
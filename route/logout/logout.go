@@ -2,11 +2,9 @@ package logout
 
 import (
   "context"
-  "encoding/json"
-  "io/ioutil"
+  "fmt"
   "micrified.com/internal/user"
   "micrified.com/route"
-  "micrified.com/service/auth"
   "net/http"
   "time"
 )
@@ -32,6 +30,10 @@ func NewController (s route.Service) Controller {
     Methods: map[string]route.Method {
       http.MethodPost: route.Restful.Post,
     },
+    Secured: map[string]bool {
+      http.MethodPost: true,
+    },
+    Access:            map[string][]string{},
     Service:           s,
     Limit:             5 * time.Second,
     Data:              logoutData{},
@@ -53,6 +55,14 @@ func (c *Controller) Timeout () time.Duration {
   return c.Limit
 }
 
+func (c *Controller) Authenticated (s string) bool {
+  return c.Secured[s]
+}
+
+func (c *Controller) Permissions (s string) []string {
+  return c.Access[s]
+}
+
 
 /*\
  *******************************************************************************
@@ -69,35 +79,34 @@ type LogoutCredential struct {}
 
 func (c *Controller) Post (x context.Context, rq *http.Request, re *route.Result) error {
   var (
-    body   []byte                          = []byte{}
-    err    error                           = nil
-    ip     string                          = x.Value(user.UserIPKey).(string)
-    logout auth.AuthData[LogoutCredential] = auth.AuthData[LogoutCredential]{}
+    err      error  = nil
+    username string = ""
+    ok       bool   = false
   )
 
-  fail := func(err error, status int) error {
-    re.Status = status
-    return err
-  }
-
-  // Read request body
-  if body, err = ioutil.ReadAll(rq.Body); nil != err {
-    return fail(err, http.StatusInternalServerError)
-  }
-
-  // Unmarshal to type
-  if err = json.Unmarshal(body, &logout); nil != err {
-    return fail(err, http.StatusBadRequest)
-  }
-
-  // Check if authorized
-  if err = c.Service.Auth.Authorized(ip, logout.Username, logout.Secret); nil != err {
-    return fail(err, http.StatusUnauthorized)
+  // Credentials were resolved ahead of the controller
+  if username, ok = user.Name(x); !ok {
+    return route.Unauthorized(fmt.Errorf("Not authenticated"))
   }
 
   // Remove the session 
-  if err = c.Service.Auth.Deauthenticate(logout.Username); nil != err {
-    return fail(err, http.StatusInternalServerError)
+  if err = c.Service.Auth.Deauthenticate(x, username); nil != err {
+    return route.Internal(err)
+  }
+  route.AuditRequest(c.Service, x, route.ActionLogout, "",
+    route.OutcomeSuccess)
+
+  // Expire the session cookie, if in use
+  if name := c.Service.Auth.Cookie(); "" != name {
+    re.Header.Add("Set-Cookie", (&http.Cookie {
+      Name:     name,
+      Value:    "",
+      Path:     "/",
+      MaxAge:   -1,
+      HttpOnly: true,
+      Secure:   true,
+      SameSite: http.SameSiteStrictMode,
+    }).String())
   }
 
   // No content is to be returned, so HTTP status code 204 is expected
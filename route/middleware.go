@@ -0,0 +1,234 @@
+package route
+
+import (
+  "context"
+  "crypto/rand"
+  "encoding/hex"
+  "fmt"
+  "log"
+  "micrified.com/internal/user"
+  "net/http"
+  "runtime/debug"
+  "sync"
+  "time"
+)
+
+
+/*\
+ *******************************************************************************
+ *                            Definition: Middleware                           *
+ *******************************************************************************
+\*/
+
+
+// Middleware: Wraps the method handling requests to a controller. It may act
+// before and after calling the next method, or answer the request itself by
+// not calling it. Middleware is bound to a controller once, when installed
+type Middleware func (Controller, Method) Method
+
+// Stacked: A controller wrapping its methods in middleware of its own. This
+// is applied within any middleware common to all controllers
+type Stacked interface {
+  Middleware() []Middleware
+}
+
+// Stack returns the middleware of the controller, if any
+func Stack (c Controller) []Middleware {
+  if m, ok := c.(Stacked); ok {
+    return m.Middleware()
+  }
+  return []Middleware{}
+}
+
+// Chain wraps the method in the middleware, outermost first
+func Chain (c Controller, m Method, ms ...Middleware) Method {
+  for i := len(ms) - 1; i >= 0; i-- {
+    m = ms[i](c, m)
+  }
+  return m
+}
+
+// Dispatch calls the method the controller handles the request method with
+func Dispatch (r Restful, x context.Context, rq *http.Request, re *Result) error {
+  c, ok := r.(Controller)
+  if !ok {
+    return re.Unimplemented()
+  }
+  if m := c.Handler(rq.Method); nil != m {
+    return m(r, x, rq, re)
+  }
+  return re.Unimplemented()
+}
+
+
+/*\
+ *******************************************************************************
+ *                         Definition: Common Middleware                       *
+ *******************************************************************************
+\*/
+
+
+// RequestID identifies the request, in the context and the response header
+func RequestID (c Controller, next Method) Method {
+  return func (r Restful, x context.Context, rq *http.Request, re *Result) error {
+    b := make([]byte, 8)
+    if _, err := rand.Read(b); nil != err {
+      return Internal(err)
+    }
+    id := hex.EncodeToString(b)
+    re.Header.Set(RequestIDName, id)
+    return next(r, user.ContextWithRequestID(x, id), rq, re)
+  }
+}
+
+// Recover turns a panic in the rest of the chain into an internal server
+// error. The panic is logged with the request ID and stack
+func Recover (c Controller, next Method) Method {
+  return func (r Restful, x context.Context, rq *http.Request, re *Result) (err error) {
+    defer func () {
+      if p := recover(); nil != p {
+        log.Printf("%s %s %s: panic: %v\n%s", user.RequestID(x), rq.URL.Path,
+          rq.Method, p, debug.Stack())
+        err = Internal(fmt.Errorf("Internal server error"))
+      }
+    }()
+    return next(r, x, rq, re)
+  }
+}
+
+// ClientIP attaches the client IP (resolved through trusted proxies) and user
+// agent to the context
+func ClientIP (s Service) Middleware {
+  return func (c Controller, next Method) Method {
+    return func (r Restful, x context.Context, rq *http.Request, re *Result) error {
+      ip, err := user.RequestIP(rq, s.Proxies)
+      if nil != err {
+        log.Printf("%s %s %s: %v\n", rq.RemoteAddr, rq.URL.Path, rq.Method, err)
+        return BadRequest(err)
+      }
+      x = user.ContextWithIP(x, ip)
+      x = user.ContextWithAgent(x, rq.UserAgent())
+      return next(r, x, rq, re)
+    }
+  }
+}
+
+// Log logs every request with its duration and error, if any
+func Log (c Controller, next Method) Method {
+  return func (r Restful, x context.Context, rq *http.Request, re *Result) error {
+    start := time.Now()
+    err := next(r, x, rq, re)
+    ip, _ := x.Value(user.UserIPKey).(string)
+    log.Printf("%s %s %s %s %d %v\n", user.RequestID(x), ip, rq.URL.Path,
+      rq.Method, time.Since(start).Milliseconds(), err)
+    return err
+  }
+}
+
+// Permit refuses requests from IPs the access rules refuse for the route and
+// method
+func Permit (s Service) Middleware {
+  return func (c Controller, next Method) Method {
+    return func (r Restful, x context.Context, rq *http.Request, re *Result) error {
+      ip, _ := x.Value(user.UserIPKey).(string)
+      if !s.Access.Permitted(c.Route(), rq.Method, ip) {
+        return Forbidden(fmt.Errorf("Access denied"))
+      }
+      return next(r, x, rq, re)
+    }
+  }
+}
+
+// Implemented refuses request methods the controller does not handle
+func Implemented (c Controller, next Method) Method {
+  return func (r Restful, x context.Context, rq *http.Request, re *Result) error {
+    if nil == c.Handler(rq.Method) {
+      return re.Unimplemented()
+    }
+    return next(r, x, rq, re)
+  }
+}
+
+// Authentication resolves the credentials of the request (see Authenticate).
+// Requests refused are audited as denied
+func Authentication (s Service) Middleware {
+  return func (c Controller, next Method) Method {
+    return func (r Restful, x context.Context, rq *http.Request, re *Result) error {
+      x, err := Authenticate(s, c, x, rq, re)
+      if nil != err {
+        denied(s, x, rq, err)
+        return err
+      }
+      return next(r, x, rq, re)
+    }
+  }
+}
+
+// Authorization enforces the permissions the controller requires (see
+// Authorize). Requests refused are audited as denied
+func Authorization (s Service) Middleware {
+  return func (c Controller, next Method) Method {
+    return func (r Restful, x context.Context, rq *http.Request, re *Result) error {
+      x, err := Authorize(s, c, x, rq, re)
+      if nil != err {
+        denied(s, x, rq, err)
+        return err
+      }
+      return next(r, x, rq, re)
+    }
+  }
+}
+
+// denied audits the request as denied, if the error refuses it as such. The
+// target is the method and path requested
+func denied (s Service, x context.Context, rq *http.Request, err error) {
+  if OutcomeDenied == Outcome(err) {
+    AuditRequest(s, x, ActionRequest, rq.Method + " " + rq.URL.Path,
+      OutcomeDenied)
+  }
+}
+
+// running: The chains running in goroutines of their own (see Timeout)
+var running sync.WaitGroup
+
+// Timeout cancels the context once the timeout of the controller passes. The
+// request then fails as unavailable, once the rest of the chain has returned.
+// The rest of the chain runs in its own goroutine, so panics are recovered
+// there too. The goroutine is tracked until it returns (see Drain)
+func Timeout (c Controller, next Method) Method {
+  next = Recover(c, next)
+  return func (r Restful, x context.Context, rq *http.Request, re *Result) error {
+    x, cancel := context.WithTimeout(x, c.Timeout())
+    defer cancel()
+    done := make(chan error, 1)
+    running.Add(1)
+    go func () {
+      defer running.Done()
+      done <- next(r, x, rq.WithContext(x), re)
+    }()
+    select {
+    case <-x.Done():
+      <-done
+      return Unavailable(x.Err())
+    case err := <-done:
+      return err
+    }
+  }
+}
+
+// Drain waits for the chains started by Timeout to return, until the context
+// ends. It is called on shutdown, before stopping the services they use,
+// since they may outlive the requests the server waited for
+func Drain (x context.Context) error {
+  done := make(chan struct{})
+  go func () {
+    running.Wait()
+    close(done)
+  }()
+  select {
+  case <-done:
+    return nil
+  case <-x.Done():
+    return x.Err()
+  }
+}
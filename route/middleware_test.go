@@ -0,0 +1,163 @@
+package route
+
+import (
+  "context"
+  "errors"
+  "io"
+  "log"
+  "net/http"
+  "net/http/httptest"
+  "os"
+  "slices"
+  "strings"
+  "testing"
+  "time"
+)
+
+
+/*\
+ *******************************************************************************
+ *                                   Helpers                                   *
+ *******************************************************************************
+\*/
+
+
+// stackedController: Wraps its methods in middleware of its own
+type stackedController struct {
+  testController
+  stack []Middleware
+}
+
+func (c *stackedController) Middleware () []Middleware {
+  return c.stack
+}
+
+// slowController: Times out sooner than it handles requests
+type slowController struct {
+  testController
+}
+
+func (c *slowController) Timeout () time.Duration {
+  return 10 * time.Millisecond
+}
+
+// record returns middleware noting the name on entry and exit
+func record (calls *[]string, name string) Middleware {
+  return func (c Controller, next Method) Method {
+    return func (r Restful, x context.Context, rq *http.Request, re *Result) error {
+      *calls = append(*calls, name + ">")
+      err := next(r, x, rq, re)
+      *calls = append(*calls, "<" + name)
+      return err
+    }
+  }
+}
+
+// call calls the method with a new request and result
+func call (c Controller, m Method, method string) error {
+  re := DefaultResult()
+  rq := httptest.NewRequest(method, c.Route(), nil)
+  return m(c, rq.Context(), rq, &re)
+}
+
+
+/*\
+ *******************************************************************************
+ *                                    Tests                                    *
+ *******************************************************************************
+\*/
+
+
+// TestChain verifies that middleware wraps the method outermost first, and
+// that the middleware of a controller applies within the common middleware
+func TestChain (t *testing.T) {
+  var calls []string
+  c := &stackedController {
+    stack: []Middleware{ record(&calls, "c") },
+  }
+  c.method = func (Restful, context.Context, *http.Request, *Result) error {
+    calls = append(calls, "method")
+    return nil
+  }
+  m := Chain(c, Dispatch, append([]Middleware {
+    record(&calls, "a"), record(&calls, "b"),
+  }, Stack(c)...)...)
+  if err := call(c, m, http.MethodGet); nil != err {
+    t.Fatalf("Chain failed: %v", err)
+  }
+  expected := []string{ "a>", "b>", "c>", "method", "<c", "<b", "<a" }
+  if !slices.Equal(expected, calls) {
+    t.Fatalf("Calls %v, expected %v", calls, expected)
+  }
+  if s := Stack(&testController{}); 0 != len(s) {
+    t.Fatalf("Controller without middleware has %d", len(s))
+  }
+}
+
+// TestImplemented verifies that methods the controller does not handle are
+// refused before reaching it
+func TestImplemented (t *testing.T) {
+  reached := false
+  m := Chain(&testController{}, func (Restful, context.Context, *http.Request,
+    *Result) error {
+    reached = true
+    return nil
+  }, Implemented)
+  err := call(&testController{}, m, http.MethodPatch)
+  if http.StatusNotImplemented != Status(err) || reached {
+    t.Fatalf("Unhandled method gave %v (reached: %v)", err, reached)
+  }
+}
+
+// TestTimeout verifies that requests outliving the timeout of the controller
+// fail as unavailable once the chain returns, that others keep their
+// outcome, and that Drain waits for the chains
+func TestTimeout (t *testing.T) {
+  c := &slowController{}
+  m := Chain(c, func (r Restful, x context.Context, rq *http.Request,
+    re *Result) error {
+    <-x.Done()
+    return x.Err()
+  }, Timeout)
+  if err := call(c, m, http.MethodGet); http.StatusServiceUnavailable !=
+    Status(err) {
+    t.Fatalf("Slow request gave %v, expected 503", err)
+  }
+
+  refused := BadRequest(errors.New("Refused"))
+  m = Chain(c, func (Restful, context.Context, *http.Request, *Result) error {
+    return refused
+  }, Timeout)
+  if err := call(c, m, http.MethodGet); refused != err {
+    t.Fatalf("Fast request gave %v, expected %v", err, refused)
+  }
+
+  x, cancel := context.WithTimeout(context.Background(), time.Second)
+  defer cancel()
+  if err := Drain(x); nil != err {
+    t.Fatalf("Drain failed: %v", err)
+  }
+}
+
+// TestRecover verifies that panics become internal server errors that do
+// not reveal the panic, including panics in the goroutine of Timeout
+func TestRecover (t *testing.T) {
+  log.SetOutput(io.Discard)
+  defer log.SetOutput(os.Stderr)
+  c := &testController{}
+  panics := func (Restful, context.Context, *http.Request, *Result) error {
+    panic("secret detail")
+  }
+  for _, m := range []Method {
+    Chain(c, panics, RequestID, Recover),
+    Chain(c, panics, Timeout),
+  } {
+    err := call(c, m, http.MethodGet)
+    if http.StatusInternalServerError != Status(err) {
+      t.Fatalf("Panic gave %v, expected 500", err)
+    }
+    if strings.Contains(err.Error(), "secret") {
+      t.Fatalf("Panic revealed: %v", err)
+    }
+  }
+}
@@ -0,0 +1,44 @@
+module micrified.com/route/passkey
+
+replace micrified.com/internal/reload => ../../internal/reload
+
+replace micrified.com/internal/user => ../../internal/user
+
+replace micrified.com/route => ../
+
+replace micrified.com/route/login => ../login
+
+replace micrified.com/service/access => ../../service/access
+
+replace micrified.com/service/auth => ../../service/auth
+
+replace micrified.com/service/database => ../../service/database
+
+replace micrified.com/service/notify => ../../service/notify
+
+replace micrified.com/service/webauthn => ../../service/webauthn
+
+go 1.22.3
+
+require (
+	micrified.com/internal/user v0.0.0-00010101000000-000000000000
+	micrified.com/route v0.0.0-00010101000000-000000000000
+	micrified.com/route/login v0.0.0-00010101000000-000000000000
+	micrified.com/service/webauthn v0.0.0-00010101000000-000000000000
+)
+
+require (
+	filippo.io/edwards25519 v1.1.0 // indirect
+	github.com/fxamacker/cbor/v2 v2.7.0 // indirect
+	github.com/go-sql-driver/mysql v1.8.1 // indirect
+	github.com/vmihailenco/msgpack/v5 v5.4.1 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
+	golang.org/x/crypto v0.23.0 // indirect
+	golang.org/x/sys v0.20.0 // indirect
+	micrified.com/internal/reload v0.0.0-00010101000000-000000000000 // indirect
+	micrified.com/service/access v0.0.0-00010101000000-000000000000 // indirect
+	micrified.com/service/auth v0.0.0-00010101000000-000000000000 // indirect
+	micrified.com/service/database v0.0.0-00010101000000-000000000000 // indirect
+	micrified.com/service/notify v0.0.0-00010101000000-000000000000 // indirect
+)
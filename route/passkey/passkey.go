@@ -0,0 +1,411 @@
+package passkey
+
+import (
+  "context"
+  "database/sql"
+  "encoding/binary"
+  "errors"
+  "fmt"
+  "micrified.com/internal/user"
+  "micrified.com/route"
+  "micrified.com/route/login"
+  "micrified.com/service/webauthn"
+  "net/http"
+  "time"
+)
+
+
+// Data: Passkey
+type passkeyData struct {
+  UserTable, CredentialTable string
+}
+
+// Controller: Passkey registration (of the authenticated user)
+type RegisterController route.ControllerType[passkeyData]
+
+// Controller: Passkey login
+type LoginController route.ControllerType[passkeyData]
+
+// newData: Shared passkey controller data
+func newData () passkeyData {
+  return passkeyData {
+    UserTable:       "users",
+    CredentialTable: "webauthn_credentials",
+  }
+}
+
+
+/*\
+ *******************************************************************************
+ *                            Interface: Controller                            *
+ *******************************************************************************
+\*/
+
+
+func NewRegisterController (s route.Service) RegisterController {
+  return RegisterController {
+    Name:             "passkey/register",
+    Methods: map[string]route.Method {
+      http.MethodPost: route.Restful.Post,
+      http.MethodPut:  route.Restful.Put,
+    },
+    Secured: map[string]bool {
+      http.MethodPost: true,
+      http.MethodPut:  true,
+    },
+    Access:           map[string][]string{},
+    Service:          s,
+    Limit:            5 * time.Second,
+    Data:             newData(),
+  }
+}
+
+func (c *RegisterController) Route () string {
+  return "/" + c.Name
+}
+
+func (c *RegisterController) Handler (s string) route.Method {
+  if method, ok := c.Methods[s]; ok {
+    return method
+  }
+  return nil
+}
+
+func (c *RegisterController) Timeout () time.Duration {
+  return c.Limit
+}
+
+func (c *RegisterController) Authenticated (s string) bool {
+  return c.Secured[s]
+}
+
+func (c *RegisterController) Permissions (s string) []string {
+  return c.Access[s]
+}
+
+// Interactive: Credentials are only managed by users themselves
+func (c *RegisterController) Interactive (s string) bool {
+  return true
+}
+
+func NewLoginController (s route.Service) LoginController {
+  return LoginController {
+    Name:             "passkey/login",
+    Methods: map[string]route.Method {
+      http.MethodPost: route.Restful.Post,
+      http.MethodPut:  route.Restful.Put,
+    },
+    Secured:          map[string]bool{},
+    Access:           map[string][]string{},
+    Service:          s,
+    Limit:            5 * time.Second,
+    Data:             newData(),
+  }
+}
+
+func (c *LoginController) Route () string {
+  return "/" + c.Name
+}
+
+func (c *LoginController) Handler (s string) route.Method {
+  if method, ok := c.Methods[s]; ok {
+    return method
+  }
+  return nil
+}
+
+func (c *LoginController) Timeout () time.Duration {
+  return c.Limit
+}
+
+func (c *LoginController) Authenticated (s string) bool {
+  return c.Secured[s]
+}
+
+func (c *LoginController) Permissions (s string) []string {
+  return c.Access[s]
+}
+
+
+/*\
+ *******************************************************************************
+ *                         Interface: Restful (Register)                       *
+ *******************************************************************************
+\*/
+
+
+func (c *RegisterController) Get (x context.Context, rq *http.Request, re *route.Result) error {
+  return re.Unimplemented()
+}
+
+// Post begins a registration ceremony for the authenticated user
+func (c *RegisterController) Post (x context.Context, rq *http.Request, re *route.Result) error {
+  var (
+    err      error  = nil
+    id       int64  = 0
+    ip       string = x.Value(user.UserIPKey).(string)
+    username string = ""
+    ok       bool   = false
+  )
+
+  // Case: Passkeys are not configured
+  if !c.Service.WebAuthn.Enabled() {
+    return re.Unimplemented()
+  }
+
+  // Credentials were resolved ahead of the controller
+  if username, ok = user.Name(x); !ok {
+    return route.Unauthorized(fmt.Errorf("Not authenticated"))
+  }
+
+  // The user handle is the (opaque) user id
+  q := fmt.Sprintf("SELECT id FROM %s WHERE username = ?", c.Data.UserTable)
+  err = c.Service.Database.DB.QueryRowContext(x, q, username).Scan(&id)
+  if nil != err {
+    return route.Internal(err)
+  }
+  handle := binary.BigEndian.AppendUint64([]byte{}, uint64(id))
+
+  // Exclude credentials already registered
+  exclude, err := credentialIDs(c.Service, x, &c.Data, username)
+  if nil != err {
+    return route.Internal(err)
+  }
+
+  options, err := c.Service.WebAuthn.BeginRegistration(ip, username, handle,
+    exclude)
+  if errors.Is(err, webauthn.ErrCeremonies) {
+    return route.TooManyRequests(err)
+  } else if nil != err {
+    return route.Internal(err)
+  }
+  return re.Marshal(&options)
+}
+
+type PasskeyRegistration struct {
+  ID string `json:"id"`
+}
+
+// Put completes the registration ceremony, storing the new credential
+func (c *RegisterController) Put (x context.Context, rq *http.Request, re *route.Result) error {
+  var (
+    err      error                         = nil
+    response webauthn.RegistrationResponse = webauthn.RegistrationResponse{}
+    username string                        = ""
+    ok       bool                          = false
+  )
+
+  // Case: Passkeys are not configured
+  if !c.Service.WebAuthn.Enabled() {
+    return re.Unimplemented()
+  }
+
+  // Credentials were resolved ahead of the controller
+  if username, ok = user.Name(x); !ok {
+    return route.Unauthorized(fmt.Errorf("Not authenticated"))
+  }
+
+  // Decode and validate request body
+  if err = route.DecodeLenient(rq, &response); nil != err {
+    return err
+  }
+
+  // Verify the ceremony was started by the same user
+  owner, credential, err := c.Service.WebAuthn.FinishRegistration(&response)
+  if nil != err {
+    return route.BadRequest(err)
+  }
+  if owner != username {
+    return route.Forbidden(fmt.Errorf("Ceremony belongs to another user"))
+  }
+
+  // Store the credential
+  q := fmt.Sprintf("INSERT INTO %s " +
+                   "(user_id, credential_id, public_key, sign_count, created) " +
+                   "SELECT id, ?, ?, ?, ? FROM %s WHERE username = ?",
+                   c.Data.CredentialTable, c.Data.UserTable)
+  _, err = c.Service.Database.DB.ExecContext(x, q, credential.ID,
+    credential.PublicKey, credential.SignCount, time.Now().UTC(), username)
+  if nil != err {
+    return route.Internal(err)
+  }
+
+  return re.Marshal(
+    &PasskeyRegistration {
+      ID: response.ID,
+    })
+}
+
+func (c *RegisterController) Delete (x context.Context, rq *http.Request, re *route.Result) error {
+  return re.Unimplemented()
+}
+
+
+/*\
+ *******************************************************************************
+ *                          Interface: Restful (Login)                         *
+ *******************************************************************************
+\*/
+
+
+func (c *LoginController) Get (x context.Context, rq *http.Request, re *route.Result) error {
+  return re.Unimplemented()
+}
+
+type PasskeyLoginRequest struct {
+  Username string `json:"userid" validate:"required,max=255"`
+}
+
+// Post begins an authentication ceremony for the named user. Options are
+// returned whether or not the user exists, so as not to reveal accounts
+func (c *LoginController) Post (x context.Context, rq *http.Request, re *route.Result) error {
+  var (
+    err     error               = nil
+    ip      string              = x.Value(user.UserIPKey).(string)
+    request PasskeyLoginRequest = PasskeyLoginRequest{}
+  )
+
+  // Case: Passkeys are not configured
+  if !c.Service.WebAuthn.Enabled() {
+    return re.Unimplemented()
+  }
+
+  // Decode and validate request body
+  if err = route.Decode(rq, &request); nil != err {
+    return err
+  }
+
+  // Check if a retry penalty exists (IP must exist)
+  if c.Service.Auth.Penalised(x, ip) {
+    return route.TooManyRequests(fmt.Errorf("Try again later"))
+  }
+
+  allow, err := credentialIDs(c.Service, x, &c.Data, request.Username)
+  if nil != err {
+    return route.Internal(err)
+  }
+
+  options, err := c.Service.WebAuthn.BeginLogin(ip, request.Username, allow)
+  if errors.Is(err, webauthn.ErrCeremonies) {
+    return route.TooManyRequests(err)
+  } else if nil != err {
+    return route.Internal(err)
+  }
+  return re.Marshal(&options)
+}
+
+type PasskeyLogin struct {
+  Credential webauthn.AuthenticationResponse `json:"credential"`
+  Period     string                          `json:"period" validate:"numeric"`
+}
+
+// Put completes the authentication ceremony. On success, the same session
+// (or token grant) is issued as for a passphrase login
+func (c *LoginController) Put (x context.Context, rq *http.Request, re *route.Result) error {
+  var (
+    err     error        = nil
+    ip      string       = x.Value(user.UserIPKey).(string)
+    request PasskeyLogin = PasskeyLogin{}
+  )
+
+  // Case: Passkeys are not configured
+  if !c.Service.WebAuthn.Enabled() {
+    return re.Unimplemented()
+  }
+
+  // Decode and validate request body (the credential may grow fields)
+  if err = route.DecodeLenient(rq, &request); nil != err {
+    return err
+  }
+
+  // Check if a retry penalty exists for the IP
+  establisher := login.NewController(c.Service)
+  if err = establisher.Throttle(x, ip, "", route.ActionLogin,
+    re); nil != err {
+    return err
+  }
+
+  // Find the ceremony answered
+  response := &request.Credential
+  z, err := c.Service.WebAuthn.Redeem(response.Response.ClientDataJSON,
+    webauthn.TypeGet)
+  if nil != err {
+    c.Service.Auth.Penalise(x, ip)
+    return route.Unauthorized(err)
+  }
+
+  // Check if the account being logged into is locked out
+  if err = establisher.Throttle(x, ip, z.Username, route.ActionLogin,
+    re); nil != err {
+    return err
+  }
+
+  // Define the authentication routine
+  doAuth := func () (bool, error) {
+    var credential webauthn.Credential
+
+    q := fmt.Sprintf("SELECT b.credential_id, b.public_key, b.sign_count " +
+                     "FROM %s AS a INNER JOIN %s AS b " +
+                     "ON a.id = b.user_id " +
+                     "WHERE a.username = ? AND b.credential_id = ? " +
+                     "AND NOT a.disabled",
+                     c.Data.UserTable, c.Data.CredentialTable)
+    err := c.Service.Database.DB.QueryRowContext(x, q, z.Username,
+      []byte(response.RawID)).Scan(&credential.ID, &credential.PublicKey,
+      &credential.SignCount)
+    if sql.ErrNoRows == err {
+      return false, nil
+    } else if nil != err {
+      return false, err
+    }
+
+    if credential, err = c.Service.WebAuthn.VerifyAssertion(response,
+      credential); nil != err {
+      return false, nil
+    }
+
+    q = fmt.Sprintf("UPDATE %s SET sign_count = ? WHERE credential_id = ?",
+      c.Data.CredentialTable)
+    _, err = c.Service.Database.DB.ExecContext(x, q, credential.SignCount,
+      credential.ID)
+    return nil == err, err
+  }
+
+  return establisher.Establish(x, z.Username, request.Period,
+    doAuth, re)
+}
+
+func (c *LoginController) Delete (x context.Context, rq *http.Request, re *route.Result) error {
+  return re.Unimplemented()
+}
+
+
+/*\
+ *******************************************************************************
+ *                                   Helpers                                   *
+ *******************************************************************************
+\*/
+
+
+// credentialIDs returns the IDs of all credentials registered to the user
+func credentialIDs (s route.Service, x context.Context, d *passkeyData,
+  username string) ([][]byte, error) {
+  ids := [][]byte{}
+  q := fmt.Sprintf("SELECT b.credential_id " +
+                   "FROM %s AS a INNER JOIN %s AS b " +
+                   "ON a.id = b.user_id " +
+                   "WHERE a.username = ?", d.UserTable, d.CredentialTable)
+  rows, err := s.Database.DB.QueryContext(x, q, username)
+  if nil != err {
+    return nil, err
+  }
+  defer rows.Close()
+  for rows.Next() {
+    var id []byte
+    if err = rows.Scan(&id); nil != err {
+      return nil, err
+    }
+    ids = append(ids, id)
+  }
+  return ids, rows.Err()
+}
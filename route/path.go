@@ -0,0 +1,61 @@
+package route
+
+import (
+  "fmt"
+  "net/http"
+  "strconv"
+)
+
+
+/*\
+ *******************************************************************************
+ *                             Definition: Patterns                            *
+ *******************************************************************************
+\*/
+
+
+// Patterned: A controller also serving paths below its route. Patterns use
+// the syntax of http.ServeMux, e.g. "/blog/{id}" or "/blog/{id}/revisions".
+// Every pattern is handled by the controller like its route
+type Patterned interface {
+  Patterns() []string
+}
+
+// Patterns returns the route of the controller, followed by any patterns it
+// serves
+func Patterns (c Controller) []string {
+  patterns := []string{ c.Route() }
+  if p, ok := c.(Patterned); ok {
+    patterns = append(patterns, p.Patterns()...)
+  }
+  return patterns
+}
+
+// HasPath returns true if the request matched a pattern with the named
+// parameter
+func HasPath (rq *http.Request, name string) bool {
+  return "" != rq.PathValue(name)
+}
+
+// PathString returns the named path parameter, or an error if the request
+// matched no pattern with the parameter
+func PathString (rq *http.Request, name string) (string, error) {
+  value := rq.PathValue(name)
+  if "" == value {
+    return "", fmt.Errorf("Missing path parameter %q", name)
+  }
+  return value, nil
+}
+
+// PathInt returns the named path parameter as an integer
+func PathInt (rq *http.Request, name string) (int64, error) {
+  value, err := PathString(rq, name)
+  if nil != err {
+    return 0, err
+  }
+  n, err := strconv.ParseInt(value, 10, 64)
+  if nil != err {
+    return 0, fmt.Errorf("Bad path parameter %q: %s", name, value)
+  }
+  return n, nil
+}
@@ -0,0 +1,144 @@
+package route
+
+import (
+  "context"
+  "net/http"
+  "net/http/httptest"
+  "slices"
+  "testing"
+  "time"
+)
+
+
+/*\
+ *******************************************************************************
+ *                                   Helpers                                   *
+ *******************************************************************************
+\*/
+
+
+// testController: Handles every request method with its method, if set
+type testController struct {
+  method Method
+}
+
+func (c *testController) Route () string {
+  return "/test"
+}
+
+func (c *testController) Handler (s string) Method {
+  return c.method
+}
+
+func (c *testController) Timeout () time.Duration {
+  return time.Second
+}
+
+func (c *testController) Authenticated (s string) bool {
+  return false
+}
+
+func (c *testController) Permissions (s string) []string {
+  return nil
+}
+
+func (c *testController) Get (x context.Context, rq *http.Request, re *Result) error {
+  return nil
+}
+
+func (c *testController) Post (x context.Context, rq *http.Request, re *Result) error {
+  return nil
+}
+
+func (c *testController) Put (x context.Context, rq *http.Request, re *Result) error {
+  return nil
+}
+
+func (c *testController) Delete (x context.Context, rq *http.Request, re *Result) error {
+  return nil
+}
+
+// patternedController: Also serves paths below its route
+type patternedController struct {
+  testController
+}
+
+func (c *patternedController) Patterns () []string {
+  return []string{ "/test/{id}", "/test/{id}/{name}" }
+}
+
+// serve routes the request to a handler for the patterns, returning the
+// request as matched
+func serve (patterns []string, target string) *http.Request {
+  var matched *http.Request
+  mux := http.NewServeMux()
+  for _, pattern := range patterns {
+    mux.HandleFunc(pattern, func (w http.ResponseWriter, rq *http.Request) {
+      matched = rq
+    })
+  }
+  mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet,
+    target, nil))
+  return matched
+}
+
+
+/*\
+ *******************************************************************************
+ *                                    Tests                                    *
+ *******************************************************************************
+\*/
+
+
+// TestPatterns verifies that controllers serve their route, followed by any
+// patterns they declare
+func TestPatterns (t *testing.T) {
+  if p := Patterns(&testController{}); !slices.Equal([]string{ "/test" }, p) {
+    t.Fatalf("Patterns %v, expected the route only", p)
+  }
+  expected := []string{ "/test", "/test/{id}", "/test/{id}/{name}" }
+  if p := Patterns(&patternedController{}); !slices.Equal(expected, p) {
+    t.Fatalf("Patterns %v, expected %v", p, expected)
+  }
+}
+
+// TestPathInt verifies that path parameters are read as integers, and that
+// missing or malformed ones are errors
+func TestPathInt (t *testing.T) {
+  patterns := Patterns(&patternedController{})
+  for target, expected := range map[string]int64 {
+    "/test/12":       12,
+    "/test/-3":       -3,
+    "/test/007/name": 7,
+  } {
+    rq := serve(patterns, target)
+    if n, err := PathInt(rq, "id"); nil != err || expected != n {
+      t.Fatalf("%s gave %d (%v), expected %d", target, n, err, expected)
+    }
+  }
+  for _, target := range []string {
+    "/test", "/test/x", "/test/1.5", "/test/99999999999999999999",
+  } {
+    rq := serve(patterns, target)
+    if n, err := PathInt(rq, "id"); nil == err {
+      t.Fatalf("%s gave %d", target, n)
+    }
+  }
+}
+
+// TestPathString verifies that path parameters are only present if the
+// pattern matched names them
+func TestPathString (t *testing.T) {
+  patterns := Patterns(&patternedController{})
+  rq := serve(patterns, "/test/12/first")
+  if s, err := PathString(rq, "name"); nil != err || "first" != s {
+    t.Fatalf("Parameter %q (%v), expected \"first\"", s, err)
+  }
+  rq = serve(patterns, "/test")
+  if HasPath(rq, "id") {
+    t.Fatalf("Route has an identifier")
+  }
+  if _, err := PathString(rq, "id"); nil == err {
+    t.Fatalf("Missing parameter read")
+  }
+}
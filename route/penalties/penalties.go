@@ -0,0 +1,153 @@
+package penalties
+
+import (
+  "cmp"
+  "context"
+  "micrified.com/route"
+  "micrified.com/service/auth"
+  "net/http"
+  "slices"
+  "time"
+)
+
+
+// Data: Penalties
+type penaltiesData struct {
+  TimeFormat string
+}
+
+// Controller: Penalties
+type Controller route.ControllerType[penaltiesData]
+
+
+/*\
+ *******************************************************************************
+ *                            Interface: Controller                            *
+ *******************************************************************************
+\*/
+
+
+func NewController (s route.Service) Controller {
+  return Controller {
+    Name:                "penalties",
+    Methods: map[string]route.Method {
+      http.MethodGet:    route.Restful.Get,
+      http.MethodDelete: route.Restful.Delete,
+    },
+    Secured: map[string]bool {
+      http.MethodGet:    true,
+      http.MethodDelete: true,
+    },
+    Access: map[string][]string {
+      http.MethodGet:    { auth.PermissionUsersManage },
+      http.MethodDelete: { auth.PermissionUsersManage },
+    },
+    Service:             s,
+    Limit:               5 * time.Second,
+    Data: penaltiesData {
+      TimeFormat:        "2006-01-02 15:04:05",
+    },
+  }
+}
+
+func (c *Controller) Route () string {
+  return "/" + c.Name
+}
+
+func (c *Controller) Handler (s string) route.Method {
+  if method, ok := c.Methods[s]; ok {
+    return method
+  }
+  return nil
+}
+
+func (c *Controller) Timeout () time.Duration {
+  return c.Limit
+}
+
+func (c *Controller) Authenticated (s string) bool {
+  return c.Secured[s]
+}
+
+func (c *Controller) Permissions (s string) []string {
+  return c.Access[s]
+}
+
+
+/*\
+ *******************************************************************************
+ *                             Interface: Restful                              *
+ *******************************************************************************
+\*/
+
+
+type PenaltyResponse struct {
+  IP       string `json:"ip"`
+  Deadline string `json:"deadline"`
+  Count    int    `json:"count"`
+}
+
+// Get lists the IPs currently penalised, in order of their deadline
+func (c *Controller) Get (x context.Context, rq *http.Request, re *route.Result) error {
+  var (
+    list []PenaltyResponse = []PenaltyResponse{}
+    now  time.Time         = time.Now()
+  )
+
+  penalties, err := c.Service.Auth.Penalties(x)
+  if nil != err {
+    return route.Internal(err)
+  }
+
+  // Keep penalties that have not lapsed
+  for ip, penalty := range penalties {
+    if !now.Before(penalty.Deadline) {
+      continue
+    }
+    list = append(list, PenaltyResponse {
+      IP:       ip,
+      Deadline: penalty.Deadline.UTC().Format(c.Data.TimeFormat),
+      Count:    penalty.Count,
+    })
+  }
+  slices.SortFunc(list, func (a, b PenaltyResponse) int {
+    return cmp.Compare(a.Deadline, b.Deadline)
+  })
+
+  return re.Marshal(&list)
+}
+
+func (c *Controller) Post (x context.Context, rq *http.Request, re *route.Result) error {
+  return re.Unimplemented()
+}
+
+func (c *Controller) Put (x context.Context, rq *http.Request, re *route.Result) error {
+  return re.Unimplemented()
+}
+
+type PenaltyDelete struct {
+  IP string `json:"ip" validate:"required"`
+}
+
+// Delete clears the penalty of the given IP
+func (c *Controller) Delete (x context.Context, rq *http.Request, re *route.Result) error {
+  var (
+    err    error         = nil
+    target PenaltyDelete = PenaltyDelete{}
+  )
+
+  // Take the IP from the query if given; else decode the body
+  if ip := rq.URL.Query().Get("ip"); "" != ip {
+    target.IP = ip
+    err = route.Validate(&target)
+  } else {
+    err = route.Decode(rq, &target)
+  }
+  if nil != err {
+    return err
+  }
+
+  c.Service.Auth.NoPenalty(x, target.IP)
+
+  return re.NoContent()
+}
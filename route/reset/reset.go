@@ -0,0 +1,290 @@
+package reset
+
+import (
+  "context"
+  "database/sql"
+  "fmt"
+  "micrified.com/internal/user"
+  "micrified.com/route"
+  "micrified.com/service/auth"
+  "micrified.com/service/notify"
+  "net/http"
+  "time"
+)
+
+
+// Data: Reset
+type resetData struct {
+  UserTable, CredentialTable, ResetTable string
+  MinPassphrase                          int
+}
+
+// Controller: Reset
+type Controller route.ControllerType[resetData]
+
+
+/*\
+ *******************************************************************************
+ *                            Interface: Controller                            *
+ *******************************************************************************
+\*/
+
+
+func NewController (s route.Service) Controller {
+  return Controller {
+    Name:             "reset",
+    Methods: map[string]route.Method {
+      http.MethodPost: route.Restful.Post,
+      http.MethodPut:  route.Restful.Put,
+    },
+    Secured:          map[string]bool{},
+    Access:           map[string][]string{},
+    Service:          s,
+    Limit:            5 * time.Second,
+    Data: resetData {
+      UserTable:       "users",
+      CredentialTable: "credentials",
+      ResetTable:      "password_resets",
+      MinPassphrase:   12,
+    },
+  }
+}
+
+func (c *Controller) Route () string {
+  return "/" + c.Name
+}
+
+func (c *Controller) Handler (s string) route.Method {
+  if method, ok := c.Methods[s]; ok {
+    return method
+  }
+  return nil
+}
+
+func (c *Controller) Timeout () time.Duration {
+  return c.Limit
+}
+
+func (c *Controller) Authenticated (s string) bool {
+  return c.Secured[s]
+}
+
+func (c *Controller) Permissions (s string) []string {
+  return c.Access[s]
+}
+
+
+/*\
+ *******************************************************************************
+ *                             Interface: Restful                              *
+ *******************************************************************************
+\*/
+
+
+func (c *Controller) Get (x context.Context, rq *http.Request, re *route.Result) error {
+  return re.Unimplemented()
+}
+
+// errRecent: A token was issued to the account within the reset interval
+var errRecent error = fmt.Errorf("Reset token issued recently")
+
+type ResetRequest struct {
+  Username string `json:"userid" validate:"required,max=255"`
+}
+
+// Post requests a passphrase reset. A single-use token is delivered to the
+// address of the user, replacing any token issued earlier. The response does
+// not reveal whether the user exists. A token is issued to an account at most
+// once per auth.ResetInterval; further requests are accepted, but deliver
+// nothing. Requests naming unknown users count towards the retry penalty of
+// the IP
+func (c *Controller) Post (x context.Context, rq *http.Request, re *route.Result) error {
+  var (
+    address   sql.NullString = sql.NullString{}
+    err       error          = nil
+    id        int64          = 0
+    ip        string         = x.Value(user.UserIPKey).(string)
+    request   ResetRequest   = ResetRequest{}
+    timeStamp time.Time      = time.Now().UTC()
+  )
+
+  // Decode and validate request body
+  if err = route.Decode(rq, &request); nil != err {
+    return err
+  }
+
+  // Check if a retry penalty exists for the IP
+  if c.Service.Auth.Penalised(x, ip) {
+    return route.TooManyRequests(fmt.Errorf("Try again later"))
+  }
+
+  // Case: No such (enabled) user, or no address to deliver to
+  q := fmt.Sprintf("SELECT id, email FROM %s WHERE username = ? AND NOT disabled",
+    c.Data.UserTable)
+  err = c.Service.Database.DB.QueryRowContext(x, q, request.Username).Scan(&id,
+    &address)
+  if sql.ErrNoRows == err {
+    c.Service.Auth.Penalise(x, ip)
+    re.Status = http.StatusAccepted
+    return nil
+  } else if nil != err {
+    return route.Internal(err)
+  } else if "" == address.String {
+    re.Status = http.StatusAccepted
+    return nil
+  }
+
+  // Issue token
+  token, digest, err := auth.NewResetToken()
+  if nil != err {
+    return route.Internal(err)
+  }
+
+  // Define check for a recent token (locking the tokens of the user)
+  checkRecent := func (lastResult sql.Result, t *sql.Tx) (sql.Result, error) {
+    var recent int
+    q := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE user_id = ? " +
+      "AND expiration > ? FOR UPDATE", c.Data.ResetTable)
+    err := t.QueryRowContext(x, q, id, timeStamp.Add(auth.ResetPeriod -
+      auth.ResetInterval)).Scan(&recent)
+    if nil == err && 0 != recent {
+      return nil, errRecent
+    }
+    return nil, err
+  }
+
+  // Define delete earlier tokens
+  deleteTokens := func (lastResult sql.Result, t *sql.Tx) (sql.Result, error) {
+    q := fmt.Sprintf("DELETE FROM %s WHERE user_id = ?", c.Data.ResetTable)
+    return t.ExecContext(x, q, id)
+  }
+
+  // Define insert token
+  insertToken := func (lastResult sql.Result, t *sql.Tx) (sql.Result, error) {
+    q := fmt.Sprintf("INSERT INTO %s (digest, user_id, expiration, used) " +
+      "VALUES (?,?,?,FALSE)", c.Data.ResetTable)
+    return t.ExecContext(x, q, digest, id,
+      timeStamp.Add(auth.ResetPeriod))
+  }
+
+  _, err = c.Service.Database.Transaction(x, checkRecent, deleteTokens,
+    insertToken)
+  if errRecent == err {
+    re.Status = http.StatusAccepted
+    return nil
+  } else if nil != err {
+    return route.Internal(err)
+  }
+
+  // Deliver token
+  err = c.Service.Notify.Notify(notify.Message {
+    To:      address.String,
+    Subject: "Passphrase reset",
+    Body:    fmt.Sprintf("A passphrase reset was requested for the account " +
+      "%s. Use the following token within %v to choose a new passphrase:\n\n" +
+      "%s\n\nIf you did not request this, you may ignore this message.",
+      request.Username, auth.ResetPeriod, token),
+  })
+  if nil != err {
+    return route.Internal(err)
+  }
+
+  re.Status = http.StatusAccepted
+  return nil
+}
+
+type ResetComplete struct {
+  Token      string `json:"token"      validate:"required"`
+  Passphrase string `json:"passphrase" validate:"required"`
+}
+
+// Put completes a passphrase reset. The token is spent, the new passphrase
+// stored, and all sessions of the user ended. Unknown, spent or expired
+// tokens are penalised in the same way as bad login credentials
+func (c *Controller) Put (x context.Context, rq *http.Request, re *route.Result) error {
+  var (
+    complete  ResetComplete = ResetComplete{}
+    err       error         = nil
+    ip        string        = x.Value(user.UserIPKey).(string)
+    timeStamp time.Time     = time.Now().UTC()
+    username  string        = ""
+  )
+
+  // Decode and validate request body
+  if err = route.Decode(rq, &complete); nil != err {
+    return err
+  }
+  if len(complete.Passphrase) < c.Data.MinPassphrase {
+    return route.BadRequest(fmt.Errorf(
+      "Passphrase must have at least %d characters", c.Data.MinPassphrase))
+  }
+
+  // Check if a retry penalty exists (IP must exist)
+  if c.Service.Auth.Penalised(x, ip) {
+    return route.TooManyRequests(fmt.Errorf("Try again later"))
+  }
+
+  // Case: Unknown, spent or expired token
+  digest := auth.ResetDigest(complete.Token)
+  q := fmt.Sprintf("SELECT a.username FROM %s AS a INNER JOIN %s AS b " +
+                   "ON a.id = b.user_id " +
+                   "WHERE b.digest = ? AND NOT b.used AND b.expiration > ? " +
+                   "AND NOT a.disabled", c.Data.UserTable, c.Data.ResetTable)
+  err = c.Service.Database.DB.QueryRowContext(x, q, digest, timeStamp).
+    Scan(&username)
+  if sql.ErrNoRows == err {
+    c.Service.Auth.Penalise(x, ip)
+    return route.Unauthorized(fmt.Errorf("Invalid reset token"))
+  } else if nil != err {
+    return route.Internal(err)
+  }
+
+  // Derive the stored secret
+  hash, salt, err := auth.NewSecret(complete.Passphrase)
+  if nil != err {
+    return route.Internal(err)
+  }
+
+  // Define spend token (only one request may succeed)
+  spendToken := func (lastResult sql.Result, t *sql.Tx) (sql.Result, error) {
+    q := fmt.Sprintf("UPDATE %s SET used = TRUE " +
+      "WHERE digest = ? AND NOT used AND expiration > ?", c.Data.ResetTable)
+    r, err := t.ExecContext(x, q, digest, timeStamp)
+    if nil != err {
+      return nil, err
+    }
+    if rows, err := r.RowsAffected(); nil != err {
+      return nil, err
+    } else if 1 != rows {
+      return nil, fmt.Errorf("Reset token already used")
+    }
+    return r, nil
+  }
+
+  // Define update credential
+  updateCredential := func (lastResult sql.Result, t *sql.Tx) (sql.Result, error) {
+    q := fmt.Sprintf("UPDATE %s AS a INNER JOIN %s AS b ON a.user_id = b.user_id " +
+      "SET a.hash = ?, a.salt = ? WHERE b.digest = ?", c.Data.CredentialTable,
+      c.Data.ResetTable)
+    return t.ExecContext(x, q, auth.ToByteSlice(hash),
+      auth.ToByteSlice(salt), digest)
+  }
+
+  if _, err = c.Service.Database.Transaction(x, spendToken,
+    updateCredential); nil != err {
+    return route.Internal(err)
+  }
+
+  // End all sessions; lift the IP penalty and the account lockout
+  if err = c.Service.Auth.Revoke(x, username); nil != err {
+    return route.Internal(err)
+  }
+  c.Service.Auth.NoPenalty(x, ip)
+  c.Service.Auth.NoAccountPenalty(x, username)
+
+  return re.NoContent()
+}
+
+func (c *Controller) Delete (x context.Context, rq *http.Request, re *route.Result) error {
+  return re.Unimplemented()
+}
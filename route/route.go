@@ -3,18 +3,34 @@ package route
 import (
   "bytes"
   "context"
-  "encoding/json"
   "fmt"
+  "micrified.com/internal/user"
+  "micrified.com/service/access"
   "micrified.com/service/auth"
   "micrified.com/service/database"
+  "micrified.com/service/notify"
+  "micrified.com/service/webauthn"
+  "mime"
   "net/http"
   "time"
 )
 
 const (
-  ContentTypeName  = "Content-Type"
-  ContentTypeJSON  = "application/json"
-  ContentTypePlain = "text/plain"
+  UserTable           = "users"
+  APIKeyTable         = "api_keys"
+  ContentTypeName     = "Content-Type"
+  ContentTypeJSON     = "application/json"
+  ContentTypeMsgPack  = "application/msgpack"
+  ContentTypeCBOR     = "application/cbor"
+  ContentTypeHTML     = "text/html"
+  ContentTypeXML      = "application/xml"
+  ContentTypePlain    = "text/plain"
+  AcceptName          = "Accept"
+  VaryName            = "Vary"
+  RetryAfterName      = "Retry-After"
+  AuthenticateName    = "WWW-Authenticate"
+  StrictTransportName = "Strict-Transport-Security"
+  RequestIDName       = "X-Request-Id"
 )
 
 
@@ -26,24 +42,49 @@ const (
 
 
 type Result struct {
+  Accept      string
+  Method      string
   Buffer      bytes.Buffer
   ContentType string
+  Header      http.Header
   Status      int
 }
 
-func (re *Result) Marshal (contentType string, p any) error {
-  re.ContentType = contentType
-  return json.NewEncoder(&re.Buffer).Encode(p)
-}
+// Marshal writes the payload in the media type the client prefers among
+// the encoders applying to it (see Negotiate). If the client accepts none of
+// them, safe requests are refused (406). Other requests may have been acted
+// on already, so they are answered with the first encoder applying (JSON)
+func (re *Result) Marshal (p any) error {
+  offers := []string{}
+  for _, e := range encoders {
+    if nil == e.Encodes || e.Encodes(p) {
+      offers = append(offers, e.MediaType)
+    }
+  }
+  re.Header.Add(VaryName, AcceptName)
 
-func (re *Result) ErrorWithStatus (err error, status int) error {
-  re.Status = status
-  return err
+  mediaType, ok := Negotiate(re.Accept, offers)
+  if !ok && (safe(re.Method) || 0 == len(offers)) {
+    return notAcceptable(offers)
+  } else if !ok {
+    mediaType = offers[0]
+  }
+  for _, e := range encoders {
+    if e.MediaType != mediaType {
+      continue
+    }
+    re.ContentType = mediaType
+    if e.Text {
+      re.ContentType = mime.FormatMediaType(mediaType,
+        map[string]string{ "charset": "utf-8" })
+    }
+    return e.Encode(&re.Buffer, p)
+  }
+  return nil
 }
 
 func (re *Result) Unimplemented () error {
-  re.Status = http.StatusNotImplemented
-  return fmt.Errorf("Invalid API call")
+  return NewError(http.StatusNotImplemented, fmt.Errorf("Invalid API call"))
 }
 
 func (re *Result) NoContent () error {
@@ -55,6 +96,7 @@ func DefaultResult () Result {
   return Result {
     Buffer:      bytes.Buffer{},
     ContentType: ContentTypePlain,
+    Header:      http.Header{},
     Status:      http.StatusOK,
   }
 }
@@ -83,19 +125,33 @@ type Controller interface {
   Route() string
   Handler(string) Method
   Timeout() time.Duration
+  Authenticated(string) bool
+  Permissions(string) []string
   Restful
 }
 
+// Interactive: Implemented by controllers whose methods (e.g. those managing
+// credentials) only serve users themselves. Such methods refuse API keys
+type Interactive interface {
+  Interactive(string) bool
+}
+
 // Service structure
 type Service struct {
+  Access *access.Service
   Auth *auth.Service
   Database *database.Service
+  WebAuthn *webauthn.Service
+  Notify *notify.Service
+  Proxies user.Proxies
 }
 
 // Templated controller type generator
 type ControllerType [T any] struct {
   Name    string
   Methods map[string]Method
+  Secured map[string]bool
+  Access  map[string][]string
   Service Service
   Limit   time.Duration
   Data T
@@ -0,0 +1,127 @@
+package token
+
+import (
+  "context"
+  "fmt"
+  "micrified.com/internal/user"
+  "micrified.com/route"
+  "micrified.com/route/login"
+  "net/http"
+  "time"
+)
+
+
+// Data: Token
+type tokenData struct {
+  TimeFormat string
+}
+
+// Controller: Token
+type Controller route.ControllerType[tokenData]
+
+
+/*\
+ *******************************************************************************
+ *                            Interface: Controller                            *
+ *******************************************************************************
+\*/
+
+
+func NewController (s route.Service) Controller {
+  return Controller {
+    Name:             "token",
+    Methods: map[string]route.Method {
+      http.MethodPost: route.Restful.Post,
+    },
+    Secured:          map[string]bool{},
+    Access:           map[string][]string{},
+    Service:          s,
+    Limit:            5 * time.Second,
+    Data: tokenData {
+      TimeFormat:     "2006-01-02 15:04:05",
+    },
+  }
+}
+
+func (c *Controller) Route () string {
+  return "/" + c.Name + "/refresh"
+}
+
+func (c *Controller) Handler (s string) route.Method {
+  if method, ok := c.Methods[s]; ok {
+    return method
+  }
+  return nil
+}
+
+func (c *Controller) Timeout () time.Duration {
+  return c.Limit
+}
+
+func (c *Controller) Authenticated (s string) bool {
+  return c.Secured[s]
+}
+
+func (c *Controller) Permissions (s string) []string {
+  return c.Access[s]
+}
+
+
+/*\
+ *******************************************************************************
+ *                             Interface: Restful                              *
+ *******************************************************************************
+\*/
+
+
+func (c *Controller) Get (x context.Context, rq *http.Request, re *route.Result) error {
+  return re.Unimplemented()
+}
+
+type RefreshCredential struct {
+  Refresh string `json:"refresh" validate:"required"`
+}
+
+func (c *Controller) Post (x context.Context, rq *http.Request, re *route.Result) error {
+  var (
+    err     error             = nil
+    ip      string            = x.Value(user.UserIPKey).(string)
+    refresh RefreshCredential = RefreshCredential{}
+  )
+
+  // Case: Signed tokens are not in use
+  if !c.Service.Auth.Stateless() {
+    return re.Unimplemented()
+  }
+
+  // Decode and validate request body
+  if err = route.Decode(rq, &refresh); nil != err {
+    return err
+  }
+
+  // Check if a retry penalty exists (IP must exist)
+  if c.Service.Auth.Penalised(x, ip) {
+    return route.TooManyRequests(fmt.Errorf("Try again later"))
+  }
+
+  // Rotate the refresh token; penalise unknown or expired tokens
+  grant, err := c.Service.Auth.Renew(x, refresh.Refresh,
+    func (username string) ([]string, error) {
+      return route.Scopes(c.Service, x, username)
+    })
+  if nil != err {
+    c.Service.Auth.Penalise(x, ip)
+    return route.Unauthorized(err)
+  }
+  c.Service.Auth.NoPenalty(x, ip)
+
+  return re.Marshal(login.NewTokenCredential(&grant, c.Data.TimeFormat))
+}
+
+func (c *Controller) Put (x context.Context, rq *http.Request, re *route.Result) error {
+  return re.Unimplemented()
+}
+
+func (c *Controller) Delete (x context.Context, rq *http.Request, re *route.Result) error {
+  return re.Unimplemented()
+}
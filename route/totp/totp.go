@@ -0,0 +1,331 @@
+package totp
+
+import (
+  "context"
+  "database/sql"
+  "fmt"
+  "micrified.com/internal/user"
+  "micrified.com/route"
+  "micrified.com/service/auth"
+  "net/http"
+  "time"
+)
+
+
+// Data: TOTP
+type totpData struct {
+  Issuer, UserTable, TOTPTable, RecoveryTable string
+}
+
+// Controller: TOTP
+type Controller route.ControllerType[totpData]
+
+
+/*\
+ *******************************************************************************
+ *                            Interface: Controller                            *
+ *******************************************************************************
+\*/
+
+
+func NewController (s route.Service) Controller {
+  return Controller {
+    Name:                "totp",
+    Methods: map[string]route.Method {
+      http.MethodPost:   route.Restful.Post,
+      http.MethodPut:    route.Restful.Put,
+      http.MethodDelete: route.Restful.Delete,
+    },
+    Secured: map[string]bool {
+      http.MethodPost:   true,
+      http.MethodPut:    true,
+      http.MethodDelete: true,
+    },
+    Access:              map[string][]string{},
+    Service:             s,
+    Limit:               5 * time.Second,
+    Data: totpData {
+      Issuer:            "micrified.com",
+      UserTable:         "users",
+      TOTPTable:         "totp",
+      RecoveryTable:     "recovery_codes",
+    },
+  }
+}
+
+func (c *Controller) Route () string {
+  return "/" + c.Name
+}
+
+func (c *Controller) Handler (s string) route.Method {
+  if method, ok := c.Methods[s]; ok {
+    return method
+  }
+  return nil
+}
+
+func (c *Controller) Timeout () time.Duration {
+  return c.Limit
+}
+
+func (c *Controller) Authenticated (s string) bool {
+  return c.Secured[s]
+}
+
+func (c *Controller) Permissions (s string) []string {
+  return c.Access[s]
+}
+
+// Interactive: Credentials are only managed by users themselves
+func (c *Controller) Interactive (s string) bool {
+  return true
+}
+
+
+/*\
+ *******************************************************************************
+ *                             Interface: Restful                              *
+ *******************************************************************************
+\*/
+
+
+func (c *Controller) Get (x context.Context, rq *http.Request, re *route.Result) error {
+  return re.Unimplemented()
+}
+
+type TOTPEnrollment struct {
+  Secret string `json:"secret"`
+  URI    string `json:"uri"`
+}
+
+// Post begins enrollment: a new secret is generated and stored (disabled)
+// until confirmed with a code through Put
+func (c *Controller) Post (x context.Context, rq *http.Request, re *route.Result) error {
+  var (
+    enabled  bool   = false
+    err      error  = nil
+    secret   string = ""
+    username string = ""
+    ok       bool   = false
+  )
+
+  // Credentials were resolved ahead of the controller
+  if username, ok = user.Name(x); !ok {
+    return route.Unauthorized(fmt.Errorf("Not authenticated"))
+  }
+
+  // Case: A second factor is already enabled
+  if enabled, err = c.enabled(x, username); nil != err {
+    return route.Internal(err)
+  } else if enabled {
+    return route.Conflict(
+      fmt.Errorf("Two-factor authentication already enabled"))
+  }
+
+  // Generate and store the pending secret
+  if secret, err = auth.NewTOTPSecret(); nil != err {
+    return route.Internal(err)
+  }
+  q := fmt.Sprintf("REPLACE INTO %s (user_id, secret, enabled, last_step) " +
+                   "SELECT id, ?, FALSE, 0 FROM %s WHERE username = ?",
+                   c.Data.TOTPTable, c.Data.UserTable)
+  _, err = c.Service.Database.DB.ExecContext(x, q, secret, username)
+  if nil != err {
+    return route.Internal(err)
+  }
+
+  return re.Marshal(
+    &TOTPEnrollment {
+      Secret: secret,
+      URI:    auth.ProvisioningURI(c.Data.Issuer, username, secret),
+    })
+}
+
+type TOTPCode struct {
+  Code string `json:"code" validate:"required"`
+}
+
+type TOTPRecovery struct {
+  Codes []string `json:"codes"`
+}
+
+// Put confirms enrollment with a code from the authenticator. The second
+// factor is enabled, and a fresh set of recovery codes is returned (once)
+func (c *Controller) Put (x context.Context, rq *http.Request, re *route.Result) error {
+  var (
+    codes    []string = []string{}
+    err      error    = nil
+    ip       string   = x.Value(user.UserIPKey).(string)
+    code     TOTPCode = TOTPCode{}
+    username string   = ""
+    ok       bool     = false
+  )
+
+  // Credentials were resolved ahead of the controller
+  if username, ok = user.Name(x); !ok {
+    return route.Unauthorized(fmt.Errorf("Not authenticated"))
+  }
+
+  // Decode and validate request body
+  if err = route.Decode(rq, &code); nil != err {
+    return err
+  }
+
+  // Verify the code against the pending secret
+  id, step, err := c.verify(x, ip, username, code.Code, false)
+  if nil != err {
+    return err
+  }
+  if codes, err = auth.NewRecoveryCodes(); nil != err {
+    return route.Internal(err)
+  }
+
+  // Define enable
+  enable := func (lastResult sql.Result, t *sql.Tx) (sql.Result, error) {
+    q := fmt.Sprintf("UPDATE %s SET enabled = TRUE, last_step = ? " +
+                     "WHERE user_id = ?", c.Data.TOTPTable)
+    return t.ExecContext(x, q, step, id)
+  }
+
+  // Define replacement of recovery codes
+  replace := func (lastResult sql.Result, t *sql.Tx) (sql.Result, error) {
+    q := fmt.Sprintf("DELETE FROM %s WHERE user_id = ?", c.Data.RecoveryTable)
+    r, err := t.ExecContext(x, q, id)
+    if nil != err {
+      return nil, err
+    }
+    q = fmt.Sprintf("INSERT INTO %s (user_id, hash, salt, used) " +
+                    "VALUES (?,?,?,FALSE)", c.Data.RecoveryTable)
+    for _, code := range codes {
+      hash, salt, err := auth.NewSecret(code)
+      if nil != err {
+        return nil, err
+      }
+      r, err = t.ExecContext(x, q, id,
+        auth.ToByteSlice(hash), auth.ToByteSlice(salt))
+      if nil != err {
+        return nil, err
+      }
+    }
+    return r, nil
+  }
+
+  // Execute sequenced operations
+  if _, err = c.Service.Database.Transaction(x, enable, replace); nil != err {
+    return route.Internal(err)
+  }
+
+  return re.Marshal(&TOTPRecovery{ Codes: codes })
+}
+
+// Delete disables the second factor. The current code must be given
+func (c *Controller) Delete (x context.Context, rq *http.Request, re *route.Result) error {
+  var (
+    err      error    = nil
+    ip       string   = x.Value(user.UserIPKey).(string)
+    code     TOTPCode = TOTPCode{}
+    username string   = ""
+    ok       bool     = false
+  )
+
+  // Credentials were resolved ahead of the controller
+  if username, ok = user.Name(x); !ok {
+    return route.Unauthorized(fmt.Errorf("Not authenticated"))
+  }
+
+  // Take the code from the query if given; else decode the body
+  if value := rq.URL.Query().Get("code"); "" != value {
+    code.Code = value
+    err = route.Validate(&code)
+  } else {
+    err = route.Decode(rq, &code)
+  }
+  if nil != err {
+    return err
+  }
+
+  // Verify the code against the enabled secret
+  id, _, err := c.verify(x, ip, username, code.Code, true)
+  if nil != err {
+    return err
+  }
+
+  // Define removal of secret and recovery codes
+  remove := func (lastResult sql.Result, t *sql.Tx) (sql.Result, error) {
+    q := fmt.Sprintf("DELETE FROM %s WHERE user_id = ?", c.Data.RecoveryTable)
+    if _, err := t.ExecContext(x, q, id); nil != err {
+      return nil, err
+    }
+    q = fmt.Sprintf("DELETE FROM %s WHERE user_id = ?", c.Data.TOTPTable)
+    return t.ExecContext(x, q, id)
+  }
+
+  if _, err = c.Service.Database.Transaction(x, remove); nil != err {
+    return route.Internal(err)
+  }
+
+  return re.NoContent()
+}
+
+
+/*\
+ *******************************************************************************
+ *                                   Helpers                                   *
+ *******************************************************************************
+\*/
+
+
+// enabled returns true if the user has an enabled second factor
+func (c *Controller) enabled (x context.Context, username string) (bool, error) {
+  var enabled bool
+  q := fmt.Sprintf("SELECT b.enabled " +
+                   "FROM %s AS a INNER JOIN %s AS b " +
+                   "ON a.id = b.user_id " +
+                   "WHERE a.username = ?",
+                   c.Data.UserTable, c.Data.TOTPTable)
+  err := c.Service.Database.DB.QueryRowContext(x, q, username).Scan(&enabled)
+  if sql.ErrNoRows == err {
+    return false, nil
+  }
+  return enabled, err
+}
+
+// verify checks the code against the user's secret (which must be in the
+// given enabled state), and returns the user id and matched time step. Wrong
+// codes are penalised in the same way as bad login credentials, and refused
+// with 401 (429 while penalised, 404 without a matching enrollment)
+func (c *Controller) verify (x context.Context, ip, username, code string,
+  enabled bool) (int64, int64, error) {
+  var (
+    id     int64  = 0
+    last   int64  = 0
+    secret string = ""
+    state  bool   = false
+  )
+
+  // Check if a retry penalty exists
+  if c.Service.Auth.Penalised(x, ip) {
+    return 0, 0, route.TooManyRequests(fmt.Errorf("Try again later"))
+  }
+
+  q := fmt.Sprintf("SELECT a.id, b.secret, b.enabled, b.last_step " +
+                   "FROM %s AS a INNER JOIN %s AS b " +
+                   "ON a.id = b.user_id " +
+                   "WHERE a.username = ?",
+                   c.Data.UserTable, c.Data.TOTPTable)
+  err := c.Service.Database.DB.QueryRowContext(x, q, username).Scan(&id,
+    &secret, &state, &last)
+  if sql.ErrNoRows == err || (nil == err && state != enabled) {
+    return 0, 0, route.NotFound(fmt.Errorf("No matching two-factor enrollment"))
+  } else if nil != err {
+    return 0, 0, route.Internal(err)
+  }
+
+  step, ok := auth.VerifyTOTP(secret, code, time.Now().UTC(), last)
+  if !ok {
+    c.Service.Auth.Penalise(x, ip)
+    return 0, 0, route.Unauthorized(fmt.Errorf("Bad code"))
+  }
+  c.Service.Auth.NoPenalty(x, ip)
+  return id, step, nil
+}
@@ -0,0 +1,496 @@
+package users
+
+import (
+  "context"
+  "database/sql"
+  "fmt"
+  "micrified.com/internal/user"
+  "micrified.com/route"
+  "micrified.com/service/auth"
+  "micrified.com/service/database"
+  "net/http"
+  "strconv"
+  "time"
+)
+
+
+// Data: Users
+type usersData struct {
+  UserTable, CredentialTable string
+  MinPassphrase              int
+}
+
+// Controller: Users
+type Controller route.ControllerType[usersData]
+
+// Controller: User sessions
+type SessionsController route.ControllerType[usersData]
+
+// newData: Shared user controller data
+func newData () usersData {
+  return usersData {
+    UserTable:       "users",
+    CredentialTable: "credentials",
+    MinPassphrase:   12,
+  }
+}
+
+
+/*\
+ *******************************************************************************
+ *                            Interface: Controller                            *
+ *******************************************************************************
+\*/
+
+
+func NewController (s route.Service) Controller {
+  return Controller {
+    Name:              "users",
+    Methods: map[string]route.Method {
+      http.MethodGet:  route.Restful.Get,
+      http.MethodPost: route.Restful.Post,
+      http.MethodPut:  route.Restful.Put,
+    },
+    Secured: map[string]bool {
+      http.MethodGet:  true,
+      http.MethodPost: true,
+      http.MethodPut:  true,
+    },
+    Access: map[string][]string {
+      http.MethodGet:  { auth.PermissionUsersManage },
+      http.MethodPost: { auth.PermissionUsersManage },
+    },
+    Service:           s,
+    Limit:             5 * time.Second,
+    Data:              newData(),
+  }
+}
+
+func (c *Controller) Route () string {
+  return "/" + c.Name
+}
+
+func (c *Controller) Handler (s string) route.Method {
+  if method, ok := c.Methods[s]; ok {
+    return method
+  }
+  return nil
+}
+
+func (c *Controller) Timeout () time.Duration {
+  return c.Limit
+}
+
+func (c *Controller) Authenticated (s string) bool {
+  return c.Secured[s]
+}
+
+func (c *Controller) Permissions (s string) []string {
+  return c.Access[s]
+}
+
+func NewSessionsController (s route.Service) SessionsController {
+  return SessionsController {
+    Name:                "users/sessions",
+    Methods: map[string]route.Method {
+      http.MethodDelete: route.Restful.Delete,
+    },
+    Secured: map[string]bool {
+      http.MethodDelete: true,
+    },
+    Access:              map[string][]string{},
+    Service:             s,
+    Limit:               5 * time.Second,
+    Data:                newData(),
+  }
+}
+
+func (c *SessionsController) Route () string {
+  return "/" + c.Name
+}
+
+func (c *SessionsController) Handler (s string) route.Method {
+  if method, ok := c.Methods[s]; ok {
+    return method
+  }
+  return nil
+}
+
+func (c *SessionsController) Timeout () time.Duration {
+  return c.Limit
+}
+
+func (c *SessionsController) Authenticated (s string) bool {
+  return c.Secured[s]
+}
+
+func (c *SessionsController) Permissions (s string) []string {
+  return c.Access[s]
+}
+
+
+/*\
+ *******************************************************************************
+ *                          Interface: Restful (Users)                         *
+ *******************************************************************************
+\*/
+
+
+type UserResponse struct {
+  ID       string `json:"id"`
+  Username string `json:"userid"`
+  Role     string `json:"role"`
+  Disabled bool   `json:"disabled"`
+}
+
+func (c *Controller) Get (x context.Context, rq *http.Request, re *route.Result) error {
+  var (
+    id   int64
+    head UserResponse
+    list []UserResponse = []UserResponse{}
+  )
+  q := fmt.Sprintf("SELECT id, username, role, disabled FROM %s ORDER BY id",
+    c.Data.UserTable)
+
+  // Extract rows
+  rows, err := c.Service.Database.DB.QueryContext(x, q)
+  if nil != err {
+    return route.Internal(err)
+  }
+  defer rows.Close()
+
+  // Marshall rows
+  for rows.Next() {
+    if err = rows.Scan(&id, &head.Username, &head.Role,
+      &head.Disabled); nil != err {
+      return route.Internal(err)
+    }
+    head.ID = strconv.FormatInt(id, 10)
+    list = append(list, head)
+  }
+  if err = rows.Err(); nil != err {
+    return route.Internal(err)
+  }
+
+  return re.Marshal(&list)
+}
+
+type UserPost struct {
+  Username   string `json:"userid"     validate:"required,max=255"`
+  Passphrase string `json:"passphrase" validate:"required"`
+  Role       string `json:"role"`
+  Email      string `json:"email"      validate:"max=255"`
+}
+
+// Post creates a new (enabled) user with the given passphrase and role. The
+// role defaults to author. The optional email address receives passphrase
+// reset tokens
+func (c *Controller) Post (x context.Context, rq *http.Request, re *route.Result) error {
+  var (
+    err    error    = nil
+    exists bool     = false
+    post   UserPost = UserPost{}
+  )
+
+  // Decode and validate request body
+  if err = route.Decode(rq, &post); nil != err {
+    return err
+  }
+
+  // Validate what depends on configuration
+  if "" == post.Role {
+    post.Role = auth.RoleAuthor
+  } else if !auth.ValidRole(post.Role) {
+    return route.BadRequest(fmt.Errorf("No such role %q", post.Role))
+  }
+  if len(post.Passphrase) < c.Data.MinPassphrase {
+    return route.BadRequest(fmt.Errorf(
+      "Passphrase must have at least %d characters", c.Data.MinPassphrase))
+  }
+
+  // Case: The username is taken
+  q := fmt.Sprintf("SELECT EXISTS(SELECT 1 FROM %s WHERE username = ?)",
+    c.Data.UserTable)
+  err = c.Service.Database.DB.QueryRowContext(x, q, post.Username).Scan(&exists)
+  if nil != err {
+    return route.Internal(err)
+  } else if exists {
+    return route.Conflict(fmt.Errorf("User %s already exists", post.Username))
+  }
+
+  // Derive the stored secret
+  hash, salt, err := auth.NewSecret(post.Passphrase)
+  if nil != err {
+    return route.Internal(err)
+  }
+
+  // Define insert user
+  insertUser := func (lastResult sql.Result, t *sql.Tx) (sql.Result, error) {
+    q := fmt.Sprintf("INSERT INTO %s (username, role, email, disabled) " +
+      "VALUES (?, ?, NULLIF(?, ''), FALSE)", c.Data.UserTable)
+    return t.ExecContext(x, q, post.Username,
+      post.Role, post.Email)
+  }
+
+  // Define insert credential
+  insertCredential := func (lastResult sql.Result, t *sql.Tx) (sql.Result, error) {
+    id, err := lastResult.LastInsertId()
+    if nil != err {
+      return nil, err
+    }
+    q := fmt.Sprintf("INSERT INTO %s (user_id, hash, salt) VALUES (?,?,?)",
+      c.Data.CredentialTable)
+    if _, err = t.ExecContext(x, q, id,
+      auth.ToByteSlice(hash), auth.ToByteSlice(salt)); nil != err {
+      return nil, err
+    }
+    return lastResult, nil
+  }
+
+  // Execute sequenced insert operations; get back result
+  r, err := c.Service.Database.Transaction(x, insertUser, insertCredential)
+  if nil != err {
+    return route.Internal(err)
+  }
+  id, err := r.LastInsertId()
+  if nil != err {
+    return route.Internal(err)
+  }
+
+  return re.Marshal(
+    &UserResponse {
+      ID:       strconv.FormatInt(id, 10),
+      Username: post.Username,
+      Role:     post.Role,
+      Disabled: false,
+    })
+}
+
+type UserPut struct {
+  Username   string `json:"userid"     validate:"max=255"`
+  Current    string `json:"current"`
+  Passphrase string `json:"passphrase"`
+  Role       string `json:"role"`
+  Disabled   *bool  `json:"disabled"`
+}
+
+// Put changes the passphrase of the authenticated user (the current
+// passphrase must be given). Users permitted to manage users may also change
+// the role of, disable, or enable another user. The changes are applied in
+// one transaction. Any change ends all sessions of the affected user
+func (c *Controller) Put (x context.Context, rq *http.Request, re *route.Result) error {
+  var (
+    err      error            = nil
+    ip       string           = x.Value(user.UserIPKey).(string)
+    put      UserPut          = UserPut{}
+    response UserResponse     = UserResponse{}
+    updates  []database.TFunc = []database.TFunc{}
+    username string           = ""
+    ok       bool             = false
+  )
+
+  // Credentials were resolved ahead of the controller
+  if username, ok = user.Name(x); !ok {
+    return route.Unauthorized(fmt.Errorf("Not authenticated"))
+  }
+
+  // Decode and validate request body; the target defaults to the
+  // authenticated user
+  if err = route.Decode(rq, &put); nil != err {
+    return err
+  }
+  if "" == put.Username {
+    put.Username = username
+  }
+
+  // Case: Managing another user
+  if "" != put.Role || nil != put.Disabled {
+    if !user.Permitted(x, auth.PermissionUsersManage) {
+      return route.Forbidden(fmt.Errorf("Not permitted"))
+    }
+    if put.Username == username {
+      return route.Forbidden(
+        fmt.Errorf("Cannot change the role or status of yourself"))
+    }
+  }
+
+  // Case: Change passphrase
+  if "" != put.Passphrase {
+    if put.Username != username {
+      return route.Forbidden(
+        fmt.Errorf("Cannot change the passphrase of another user"))
+    }
+    update, err := c.changePassphrase(x, ip, &put)
+    if nil != err {
+      return err
+    }
+    updates = append(updates, update)
+  }
+
+  // Case: Change role
+  if "" != put.Role {
+    if !auth.ValidRole(put.Role) {
+      return route.BadRequest(fmt.Errorf("No such role %q", put.Role))
+    }
+    updates = append(updates, c.update(x, "role", put.Role, put.Username))
+  }
+
+  // Case: Disable or enable
+  if nil != put.Disabled {
+    updates = append(updates, c.update(x, "disabled",
+      *put.Disabled, put.Username))
+  }
+
+  // Describe the user (verifying it exists), as changed
+  var id int64
+  describe := func (lastResult sql.Result, t *sql.Tx) (sql.Result, error) {
+    q := fmt.Sprintf("SELECT id, username, role, disabled FROM %s " +
+      "WHERE username = ?", c.Data.UserTable)
+    return lastResult, t.QueryRowContext(x, q, put.Username).Scan(&id,
+      &response.Username, &response.Role, &response.Disabled)
+  }
+
+  _, err = c.Service.Database.Transaction(x, append(updates, describe)...)
+  if sql.ErrNoRows == err {
+    return route.NotFound(fmt.Errorf("No such user %s", put.Username))
+  } else if nil != err {
+    return route.Internal(err)
+  }
+  response.ID = strconv.FormatInt(id, 10)
+
+  // End all sessions of the affected user
+  if "" != put.Passphrase || "" != put.Role ||
+    (nil != put.Disabled && *put.Disabled) {
+    if err = c.Service.Auth.Revoke(x, put.Username); nil != err {
+      return route.Internal(err)
+    }
+  }
+
+  return re.Marshal(&response)
+}
+
+// changePassphrase verifies the current passphrase, and returns the update
+// storing the new one. Wrong passphrases are penalised in the same way as bad
+// login credentials, and refused with 401 (429 while penalised)
+func (c *Controller) changePassphrase (x context.Context, ip string,
+  put *UserPut) (database.TFunc, error) {
+  var stored struct { Hash, Salt []byte }
+
+  // Validate
+  if len(put.Passphrase) < c.Data.MinPassphrase {
+    return nil, route.BadRequest(fmt.Errorf("Passphrase must have at " +
+      "least %d characters", c.Data.MinPassphrase))
+  }
+
+  // Check if a retry penalty exists
+  if c.Service.Auth.Penalised(x, ip) {
+    return nil, route.TooManyRequests(fmt.Errorf("Try again later"))
+  }
+
+  // Verify the current passphrase
+  q := fmt.Sprintf("SELECT b.hash, b.salt " +
+                   "FROM %s AS a INNER JOIN %s AS b " +
+                   "ON a.id = b.user_id " +
+                   "WHERE a.username = ?",
+                   c.Data.UserTable, c.Data.CredentialTable)
+  err := c.Service.Database.DB.QueryRowContext(x, q, put.Username).
+    Scan(&stored.Hash, &stored.Salt)
+  if nil != err {
+    return nil, route.Internal(err)
+  }
+  if !auth.Compare(put.Current, stored.Salt, stored.Hash) {
+    c.Service.Auth.Penalise(x, ip)
+    return nil, route.Unauthorized(fmt.Errorf("Bad credentials"))
+  }
+  c.Service.Auth.NoPenalty(x, ip)
+
+  // Store the new passphrase
+  hash, salt, err := auth.NewSecret(put.Passphrase)
+  if nil != err {
+    return nil, route.Internal(err)
+  }
+  return func (lastResult sql.Result, t *sql.Tx) (sql.Result, error) {
+    q := fmt.Sprintf("UPDATE %s AS a INNER JOIN %s AS b ON a.id = b.user_id " +
+                     "SET b.hash = ?, b.salt = ? WHERE a.username = ?",
+                     c.Data.UserTable, c.Data.CredentialTable)
+    return t.ExecContext(x, q, auth.ToByteSlice(hash), auth.ToByteSlice(salt),
+      put.Username)
+  }, nil
+}
+
+// update returns the update setting the column of the user to the value
+func (c *Controller) update (x context.Context, column string, value any,
+  username string) database.TFunc {
+  return func (lastResult sql.Result, t *sql.Tx) (sql.Result, error) {
+    q := fmt.Sprintf("UPDATE %s SET %s = ? WHERE username = ?",
+      c.Data.UserTable, column)
+    return t.ExecContext(x, q, value, username)
+  }
+}
+
+func (c *Controller) Delete (x context.Context, rq *http.Request, re *route.Result) error {
+  return re.Unimplemented()
+}
+
+
+/*\
+ *******************************************************************************
+ *                        Interface: Restful (Sessions)                        *
+ *******************************************************************************
+\*/
+
+
+func (c *SessionsController) Get (x context.Context, rq *http.Request, re *route.Result) error {
+  return re.Unimplemented()
+}
+
+func (c *SessionsController) Post (x context.Context, rq *http.Request, re *route.Result) error {
+  return re.Unimplemented()
+}
+
+func (c *SessionsController) Put (x context.Context, rq *http.Request, re *route.Result) error {
+  return re.Unimplemented()
+}
+
+type SessionsDelete struct {
+  Username string `json:"userid"`
+}
+
+// Delete forcibly logs out the named user (or the authenticated user, if no
+// user is named) everywhere. Logging out another user requires permission to
+// manage users
+func (c *SessionsController) Delete (x context.Context, rq *http.Request, re *route.Result) error {
+  var (
+    err      error          = nil
+    target   SessionsDelete = SessionsDelete{}
+    username string         = ""
+    ok       bool           = false
+  )
+
+  // Credentials were resolved ahead of the controller
+  if username, ok = user.Name(x); !ok {
+    return route.Unauthorized(fmt.Errorf("Not authenticated"))
+  }
+
+  // Take the user from the query if given; else decode any body
+  if value := rq.URL.Query().Get("userid"); "" != value {
+    target.Username = value
+  } else if 0 != rq.ContentLength {
+    if err = route.Decode(rq, &target); nil != err {
+      return err
+    }
+  }
+  if "" == target.Username {
+    target.Username = username
+  }
+  if target.Username != username &&
+    !user.Permitted(x, auth.PermissionUsersManage) {
+    return route.Forbidden(fmt.Errorf("Not permitted"))
+  }
+
+  if err := c.Service.Auth.Revoke(x, target.Username); nil != err {
+    return route.Internal(err)
+  }
+
+  return re.NoContent()
+}
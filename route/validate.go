@@ -0,0 +1,212 @@
+package route
+
+import (
+  "fmt"
+  "reflect"
+  "strconv"
+  "strings"
+  "unicode/utf8"
+)
+
+const (
+  ValidateTag = "validate"
+)
+
+
+/*\
+ *******************************************************************************
+ *                            Definition: Validation                           *
+ *******************************************************************************
+\*/
+
+
+// Validator: A payload checking what its tags cannot express (e.g. fields
+// required only together). It returns the failing fields, and why
+type Validator interface {
+  Validate() map[string]string
+}
+
+// Validate checks the payload (a pointer to a struct) against the rules in
+// the validate tags of its fields, and then against its Validate method,
+// if any. Fields are named as in JSON. Rules are separated by commas:
+//
+//   required   Must not be empty (or blank)
+//   min=N      At least N characters or elements, or at least N
+//   max=N      At most N characters or elements, or at most N
+//   numeric    Must be an integer (of strings only)
+//   oneof=A B  Must be one of the space separated values
+//
+// All but required only apply to fields that are not empty. Unknown or
+// malformed rules, and rules not applying to the kind of their field, fail
+// validation with an internal error
+func Validate (v any) error {
+  fields := map[string]string{}
+
+  value := reflect.Indirect(reflect.ValueOf(v))
+  if reflect.Struct == value.Kind() {
+    for i := 0; i < value.NumField(); i++ {
+      f := value.Type().Field(i)
+      rules, ok := f.Tag.Lookup(ValidateTag)
+      if !ok || !f.IsExported() {
+        continue
+      }
+      reason, err := check(value.Field(i), rules)
+      if nil != err {
+        return Internal(fmt.Errorf("Field %s: %w", f.Name, err))
+      }
+      if "" != reason {
+        fields[jsonName(f)] = reason
+      }
+    }
+  }
+
+  // Fields failing the tags take precedence
+  if z, ok := v.(Validator); ok {
+    for name, reason := range z.Validate() {
+      if _, failed := fields[name]; !failed {
+        fields[name] = reason
+      }
+    }
+  }
+
+  if 0 == len(fields) {
+    return nil
+  }
+  return Validation(fields)
+}
+
+// jsonName returns the name of the field in JSON
+func jsonName (f reflect.StructField) string {
+  name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+  if "" == name || "-" == name {
+    return f.Name
+  }
+  return name
+}
+
+// tag: A rule, with its argument (if any)
+type tag struct {
+  name, arg string
+}
+
+// check returns why the value fails the rules, or the empty string. It
+// returns an error if any rule is unknown, malformed, or does not apply to
+// the kind of the value
+func check (v reflect.Value, rules string) (string, error) {
+  empty := v.IsZero() ||
+    (reflect.String == v.Kind() && "" == strings.TrimSpace(v.String())) ||
+    ((reflect.Slice == v.Kind() || reflect.Map == v.Kind()) && 0 == v.Len())
+
+  // Check all rules, whether or not they are applied
+  tags := []tag{}
+  for _, rule := range strings.Split(rules, ",") {
+    name, arg, _ := strings.Cut(strings.TrimSpace(rule), "=")
+    if err := checkTag(v.Kind(), name, arg); nil != err {
+      return "", err
+    }
+    tags = append(tags, tag { name: name, arg: arg })
+  }
+
+  for _, t := range tags {
+    if "required" == t.name {
+      if empty {
+        return "is required", nil
+      }
+      continue
+    }
+    if empty {
+      continue
+    }
+    if reason := checkRule(v, t.name, t.arg); "" != reason {
+      return reason, nil
+    }
+  }
+  return "", nil
+}
+
+// checkTag returns an error if the rule is unknown, malformed, or does not
+// apply to values of the kind
+func checkTag (kind reflect.Kind, name, arg string) error {
+  switch name {
+  case "required", "oneof":
+  case "min", "max":
+    if _, err := strconv.ParseFloat(arg, 64); nil != err {
+      return fmt.Errorf("Bad %s rule %q", name, arg)
+    }
+    if _, ok := units[kind]; !ok {
+      return fmt.Errorf("Rule %s does not apply to %s", name, kind)
+    }
+  case "numeric":
+    if reflect.String != kind {
+      return fmt.Errorf("Rule %s does not apply to %s", name, kind)
+    }
+  default:
+    return fmt.Errorf("Unknown validation rule %q", name)
+  }
+  return nil
+}
+
+// checkRule returns why the (non-empty) value fails the (checked) rule
+func checkRule (v reflect.Value, name, arg string) string {
+  switch name {
+  case "min", "max":
+    bound, _ := strconv.ParseFloat(arg, 64)
+    size, unit := measure(v)
+    if "min" == name && size < bound {
+      return fmt.Sprintf("must be at least %s%s", arg, unit)
+    }
+    if "max" == name && size > bound {
+      return fmt.Sprintf("must be at most %s%s", arg, unit)
+    }
+  case "numeric":
+    if _, err := strconv.ParseInt(v.String(), 10, 64); nil != err {
+      return "must be an integer"
+    }
+  case "oneof":
+    values := strings.Fields(arg)
+    for _, value := range values {
+      if fmt.Sprint(v.Interface()) == value {
+        return ""
+      }
+    }
+    return "must be one of: " + strings.Join(values, ", ")
+  }
+  return ""
+}
+
+// units: The kinds of value min and max rules apply to, and the unit of their
+// size
+var units map[reflect.Kind]string = map[reflect.Kind]string {
+  reflect.String:  " characters",
+  reflect.Slice:   " elements",
+  reflect.Map:     " elements",
+  reflect.Array:   " elements",
+  reflect.Int:     "",
+  reflect.Int8:    "",
+  reflect.Int16:   "",
+  reflect.Int32:   "",
+  reflect.Int64:   "",
+  reflect.Uint:    "",
+  reflect.Uint8:   "",
+  reflect.Uint16:  "",
+  reflect.Uint32:  "",
+  reflect.Uint64:  "",
+  reflect.Float32: "",
+  reflect.Float64: "",
+}
+
+// measure returns the size of the value compared by min and max rules, and
+// its unit. The kind of the value must be one of units
+func measure (v reflect.Value) (float64, string) {
+  switch v.Kind() {
+  case reflect.String:
+    return float64(utf8.RuneCountInString(v.String())), units[v.Kind()]
+  case reflect.Slice, reflect.Map, reflect.Array:
+    return float64(v.Len()), units[v.Kind()]
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+    return float64(v.Int()), ""
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+    return float64(v.Uint()), ""
+  }
+  return v.Float(), ""
+}
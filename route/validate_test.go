@@ -0,0 +1,141 @@
+package route
+
+import (
+  "errors"
+  "maps"
+  "net/http"
+  "reflect"
+  "testing"
+)
+
+
+/*\
+ *******************************************************************************
+ *                                   Helpers                                   *
+ *******************************************************************************
+\*/
+
+
+// testPayload exercises every rule, and a Validate method
+type testPayload struct {
+  Name    string   `json:"name"    validate:"required,min=2,max=4"`
+  Period  string   `json:"period"  validate:"numeric"`
+  Kind    string   `json:"kind"    validate:"oneof=a b"`
+  Count   int      `json:"count"   validate:"max=10"`
+  Tags    []string `json:"tags"    validate:"max=2"`
+  Other   string   `json:"other"`
+  Skipped string   `json:"-"       validate:"required"`
+}
+
+func (p *testPayload) Validate () map[string]string {
+  if "" != p.Other && "" == p.Kind {
+    return map[string]string{ "kind": "is required with other" }
+  }
+  return nil
+}
+
+// fields returns the fields failing validation, as returned by Validate
+func fields (t *testing.T, err error) map[string]string {
+  var e *Error
+  if nil == err {
+    return map[string]string{}
+  }
+  if !errors.As(err, &e) || http.StatusUnprocessableEntity != e.Status {
+    t.Fatalf("Unexpected error: %v", err)
+  }
+  return e.Fields
+}
+
+
+/*\
+ *******************************************************************************
+ *                                    Tests                                    *
+ *******************************************************************************
+\*/
+
+
+// TestValidate verifies the fields reported as failing, and why
+func TestValidate (t *testing.T) {
+  cases := []struct {
+    Payload testPayload
+    Fields  map[string]string
+  } {
+    { testPayload { Name: "abc", Skipped: "x" }, map[string]string{} },
+    { testPayload { Name: "  ", Skipped: "x" },
+      map[string]string{ "name": "is required" } },
+    { testPayload { Name: "a", Skipped: "x" },
+      map[string]string{ "name": "must be at least 2 characters" } },
+    { testPayload { Name: "äöüßé", Skipped: "x" },
+      map[string]string{ "name": "must be at most 4 characters" } },
+    { testPayload { Name: "abc", Period: "1.5", Kind: "c", Count: 11,
+      Tags: []string{ "a", "b", "c" }, Skipped: "x" },
+      map[string]string {
+        "period": "must be an integer",
+        "kind":   "must be one of: a, b",
+        "count":  "must be at most 10",
+        "tags":   "must be at most 2 elements",
+      } },
+    { testPayload { Name: "abc", Period: "-15", Kind: "b", Count: 10 },
+      map[string]string{ "Skipped": "is required" } },
+    { testPayload { Name: "abc", Other: "x", Skipped: "x" },
+      map[string]string{ "kind": "is required with other" } },
+    { testPayload { Other: "x", Skipped: "x" },
+      map[string]string {
+        "name": "is required",
+        "kind": "is required with other",
+      } },
+  }
+  for i, c := range cases {
+    if f := fields(t, Validate(&c.Payload)); !maps.Equal(c.Fields, f) {
+      t.Fatalf("Case %d failed %v, expected %v", i, f, c.Fields)
+    }
+  }
+}
+
+// TestValidateTags verifies that unknown or malformed rules, and rules not
+// applying to their field, fail with an internal error (even if empty)
+func TestValidateTags (t *testing.T) {
+  payloads := []any {
+    &struct { A string `validate:"requird"` }{},
+    &struct { A string `validate:"max=ten"` }{ A: "a" },
+    &struct { A string `validate:"min"` }{},
+    &struct { A int    `validate:"numeric"` }{ A: 1 },
+    &struct { A bool   `validate:"max=1"` }{},
+    &struct { A *int   `validate:"min=1"` }{},
+  }
+  for i, p := range payloads {
+    if err := Validate(p); http.StatusInternalServerError != Status(err) {
+      t.Fatalf("Payload %d gave %v", i, err)
+    }
+  }
+}
+
+// TestCheck verifies the rules of a tag, as applied to single values
+func TestCheck (t *testing.T) {
+  cases := []struct {
+    Value  any
+    Rules  string
+    Reason string
+  } {
+    { "", "numeric", "" },
+    { "12", "required, numeric", "" },
+    { "12a", "numeric", "must be an integer" },
+    { 0, "required", "is required" },
+    { 0, "min=1", "" },
+    { 2.5, "min=3", "must be at least 3" },
+    { uint(7), "max=6", "must be at most 6" },
+    { []int{}, "required", "is required" },
+    { map[string]int{ "a": 1 }, "min=2", "must be at least 2 elements" },
+    { 3, "oneof=1 2 3", "" },
+  }
+  for _, c := range cases {
+    reason, err := check(reflect.ValueOf(c.Value), c.Rules)
+    if nil != err {
+      t.Fatalf("%v (%s) failed: %v", c.Value, c.Rules, err)
+    }
+    if c.Reason != reason {
+      t.Fatalf("%v (%s) gave %q, expected %q", c.Value, c.Rules, reason,
+        c.Reason)
+    }
+  }
+}
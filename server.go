@@ -3,78 +3,62 @@ import (
   "context"
   "fmt"
   "log"
+  "net"
   "micrified.com/internal/user"
   "micrified.com/route"
+  "micrified.com/route/apikeys"
+  "micrified.com/route/audit"
   "micrified.com/route/blog"
   "micrified.com/route/login"
   "micrified.com/route/logout"
+  "micrified.com/route/passkey"
+  "micrified.com/route/penalties"
+  "micrified.com/route/reset"
+  "micrified.com/route/token"
+  "micrified.com/route/totp"
+  "micrified.com/route/users"
+  "micrified.com/service/access"
   "micrified.com/service/auth"
+  "micrified.com/service/certificate"
   "micrified.com/service/database"
+  "micrified.com/service/notify"
+  "micrified.com/service/webauthn"
   "net/http"
   "os"
+  "os/signal"
+  "slices"
+  "syscall"
   "time"
 )
 
-// handler wraps the given route handler with a context. This context provides
-// the user IP address and installs the supplied response timeout. It returns
-// a new function to be used as the handler parameter for the http SetHandle
-// function. It is imperative that any error and/or status be returned to 
-// this function
-func handler (c route.Controller) func(http.ResponseWriter, *http.Request) {
+// handler returns the function handling requests to the controller, to be
+// installed with http.HandleFunc. Requests pass through the given middleware
+// common to all controllers, then through any middleware of the controller,
+// before reaching the controller method. It is imperative that any error
+// and/or status be returned to this function
+func handler (c route.Controller, common []route.Middleware) func(http.ResponseWriter, *http.Request) {
+  method := route.Chain(c, route.Dispatch, slices.Concat(common, route.Stack(c))...)
   return func (w http.ResponseWriter, rq *http.Request) {
     var (
-      elapsed time.Duration = 0
-      err     error         = nil
-      result  route.Result = route.DefaultResult()
-      userIP  string        = ""
+      result route.Result = route.DefaultResult()
+      err    error        = method(c, rq.Context(), rq, &result)
     )
 
-    // Wrapper for timed, cancellable request
-    doCancellable := func (m route.Method, x context.Context) (time.Duration, error) {
-      start, cerr := time.Now(), make(chan error, 1)
-      defer close(cerr)
-      go func () {
-	cerr <-m(c, x, rq.WithContext(x), &result)
-      }()
-      select {
-      case <-x.Done():
-	<-cerr
-	return time.Since(start), x.Err()
-      case err := <-cerr:
-	return time.Since(start), err
-      }
-    }
-
-    // Create context
-    x, cancel := context.WithTimeout(context.Background(), c.Timeout())
-    defer cancel()
-
-    // Attach user IP; Then handle while measuring
-    userIP, err = user.RequestIP(rq)
-    if nil == err {
-      x = user.ContextWithIP(x, userIP)
-      if m := c.Handler(rq.Method); nil != m {
-	elapsed, err = doCancellable(m, x)
-      } else {
-	err = result.Unimplemented()
+    // Install any headers set by the controller
+    for key, values := range result.Header {
+      for _, value := range values {
+	w.Header().Add(key, value)
       }
     }
 
     // Process result
     if nil != err {
-      // TODO: Ensure status code is always != OK (200)? 
-      if http.StatusOK == result.Status {
-	panic("Cannot return 200 if returning error")
-      }
-      http.Error(w, err.Error(), result.Status)
+      route.WriteError(w, err, result.Header.Get(route.RequestIDName))
     } else {
       w.Header().Set(route.ContentTypeName, result.ContentType)
       w.WriteHeader(result.Status)
       w.Write(result.Buffer.Bytes())
     }
-
-    log.Printf("%s %s %s %d %v\n", userIP, rq.URL.Path, rq.Method, 
-      elapsed.Milliseconds(), err)
   }
 }
 
@@ -97,14 +81,33 @@ func main() {
     log.Fatal(err)
   }
 
+  // Setup trusted proxies
+  if s.Proxies, err = user.NewProxies(cfg.Proxies,
+    cfg.ProxyHeader); nil != err {
+    log.Fatal(err)
+  }
+
   // Setup services
+  acs, err := access.NewService(cfg.Access)
+  if nil != err {
+    log.Fatal(err)
+  } else {
+    s.Access = &acs
+  }
+  acs.Start()
+
+  cs, err := certificate.NewService(cfg.TLS)
+  if nil != err {
+    log.Fatal(err)
+  }
+  cs.Start()
+
   ds, err := database.NewService(cfg.Database)
   if nil != err {
     log.Fatal(err)
   } else {
     s.Database = &ds
   }
-  defer ds.Stop()
 
   as, err := auth.NewService(cfg.Auth)
   if nil != err {
@@ -112,24 +115,205 @@ func main() {
   } else {
     s.Auth = &as
   }
+  if auth.StoreSQL == cfg.Auth.Store {
+    as.UsePenaltyStores(auth.NewSQLPenaltyStore(ds.DB, "penalties"),
+      auth.NewSQLPenaltyStore(ds.DB, "lockouts"))
+    as.UseGrantStore(auth.NewSQLGrantStore(ds.DB, "refresh_tokens",
+      "revocations"))
+  }
+  as.Start()
+
+  ws, err := webauthn.NewService(cfg.WebAuthn)
+  if nil != err {
+    log.Fatal(err)
+  } else {
+    s.WebAuthn = &ws
+  }
+
+  ns, err := notify.NewService(cfg.Notify)
+  if nil != err {
+    log.Fatal(err)
+  } else {
+    s.Notify = &ns
+  }
 
   // Setup route controllers
+  keysController   := apikeys.NewController(s)
+  auditController  := audit.NewController(s)
   blogController   := blog.NewController(s)
   loginController  := login.NewController(s)
   logoutController := logout.NewController(s)
+  passkeyRegister  := passkey.NewRegisterController(s)
+  passkeyLogin     := passkey.NewLoginController(s)
+  penaltiesAdmin   := penalties.NewController(s)
+  resetController  := reset.NewController(s)
+  tokenController  := token.NewController(s)
+  totpController   := totp.NewController(s)
+  usersController  := users.NewController(s)
+  usersSessions    := users.NewSessionsController(s)
+
+  // Middleware common to all controllers, outermost first
+  middleware := []route.Middleware {
+    route.RequestID,
+    route.Recover,
+    route.ClientIP(s),
+    route.Log,
+    route.Permit(s),
+    route.Implemented,
+    route.Acceptable,
+    route.Bounded,
+    route.Timeout,
+    route.Authentication(s),
+    route.Authorization(s),
+  }
 
-  // Install routes
-  routes := map[string]func(http.ResponseWriter, *http.Request) {
-    blogController.Route()   : handler(&blogController),
-    loginController.Route()  : handler(&loginController),
-    logoutController.Route() : handler(&logoutController),
+  // Install routes, and any patterns below them
+  controllers := []route.Controller {
+    &keysController,
+    &auditController,
+    &blogController,
+    &loginController,
+    &logoutController,
+    &passkeyRegister,
+    &passkeyLogin,
+    &penaltiesAdmin,
+    &resetController,
+    &tokenController,
+    &totpController,
+    &usersController,
+    &usersSessions,
   }
-  for route, handle := range routes {
-    http.HandleFunc(route, handle)
+  for _, c := range controllers {
+    for _, pattern := range route.Patterns(c) {
+      http.HandleFunc(pattern, handler(c, middleware))
+    }
   }
 
-  // Listen and serve
-  url := fmt.Sprintf("%s:%s", cfg.Host, cfg.Port)
-  fmt.Printf("Listening at: %s\n", url)
-  log.Fatal(http.ListenAndServe(url, nil))
+  // Listen and serve until interrupted or terminated. Plain HTTP requests
+  // are redirected to HTTPS if configured
+  server := http.Server {
+    Addr:    fmt.Sprintf("%s:%s", cfg.Host, cfg.Port),
+    Handler: secure(http.DefaultServeMux, cs.HSTS()),
+  }
+  servers := []*http.Server{ &server }
+  serving, signals := make(chan error, 2), make(chan os.Signal, 1)
+  signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+  if cs.Enabled() {
+    server.TLSConfig = cs.TLSConfig()
+    go func () {
+      serving <- server.ListenAndServeTLS("", "")
+    }()
+  } else {
+    go func () {
+      serving <- server.ListenAndServe()
+    }()
+  }
+  fmt.Printf("Listening at: %s\n", server.Addr)
+  if cs.Enabled() && "" != cs.Redirect() {
+    redirector := http.Server {
+      Addr:    fmt.Sprintf("%s:%s", cfg.Host, cs.Redirect()),
+      Handler: redirect(cfg.Port),
+    }
+    servers = append(servers, &redirector)
+    go func () {
+      serving <- redirector.ListenAndServe()
+    }()
+    fmt.Printf("Redirecting to HTTPS at: %s\n", redirector.Addr)
+  }
+
+  // Reload the certificate on hangup; Stop on anything else
+  for running := true; running; {
+    select {
+    case err = <-serving:
+      log.Printf("Serve: %v\n", err)
+      shutdown(cfg.DrainTimeout(), servers...)
+      running = false
+    case sig := <-signals:
+      if syscall.SIGHUP != sig {
+        log.Printf("Received %v: draining requests for up to %v\n", sig,
+          cfg.DrainTimeout())
+        err = shutdown(cfg.DrainTimeout(), servers...)
+        running = false
+      } else if !cs.Enabled() {
+        log.Printf("Received %v: no certificate to reload\n", sig)
+      } else if rerr := cs.Load(); nil != rerr {
+        log.Printf("Certificate: %v\n", rerr)
+      } else {
+        log.Printf("Certificate: Reloaded on %v\n", sig)
+      }
+    }
+  }
+  signal.Stop(signals)
+
+  // Stop services once no request can use them
+  acs.Stop()
+  cs.Stop()
+  as.Stop()
+  if serr := ns.Stop(); nil != serr {
+    log.Printf("Notify: %v\n", serr)
+  }
+  if serr := ds.Stop(); nil != serr {
+    log.Printf("Database: %v\n", serr)
+  }
+  if nil != err {
+    os.Exit(1)
+  }
+}
+
+// shutdown stops the servers accepting connections, and waits for requests
+// in flight to finish for at most the drain timeout. Connections still busy
+// after that are closed, cancelling their requests, and their controllers
+// are given up to the drain timeout again to return (see route.Drain)
+func shutdown (drain time.Duration, servers ...*http.Server) error {
+  x, cancel := context.WithTimeout(context.Background(), drain)
+  defer cancel()
+  for _, server := range servers {
+    if err := server.Shutdown(x); nil != err {
+      log.Printf("Shutdown: %v\n", err)
+      for _, server := range servers {
+        server.Close()
+      }
+      x, cancel := context.WithTimeout(context.Background(), drain)
+      defer cancel()
+      if derr := route.Drain(x); nil != derr {
+        log.Printf("Shutdown: Controllers still running: %v\n", derr)
+      }
+      return err
+    }
+  }
+  if err := route.Drain(x); nil != err {
+    log.Printf("Shutdown: Controllers still running: %v\n", err)
+    return err
+  }
+  log.Printf("Shutdown: All requests drained\n")
+  return nil
+}
+
+// secure adds the Strict-Transport-Security header to every response, if
+// the header is configured
+func secure (next http.Handler, hsts string) http.Handler {
+  if "" == hsts {
+    return next
+  }
+  return http.HandlerFunc(func (w http.ResponseWriter, rq *http.Request) {
+    w.Header().Set(route.StrictTransportName, hsts)
+    next.ServeHTTP(w, rq)
+  })
+}
+
+// redirect returns a handler redirecting requests to the same host and path
+// at the HTTPS port
+func redirect (port string) http.HandlerFunc {
+  return func (w http.ResponseWriter, rq *http.Request) {
+    host, _, err := net.SplitHostPort(rq.Host)
+    if nil != err {
+      host = rq.Host
+    }
+    if "443" != port {
+      host = net.JoinHostPort(host, port)
+    }
+    target := *rq.URL
+    target.Scheme, target.Host = "https", host
+    http.Redirect(w, rq, target.String(), http.StatusPermanentRedirect)
+  }
 }
@@ -0,0 +1,247 @@
+// Package access restricts which networks may reach which routes. Rules name
+// a route and (optionally) the methods they apply to, and list the CIDR
+// ranges allowed or denied. The rules are read from a JSON file, which is
+// reloaded whenever it changes
+
+package access
+
+import (
+  "encoding/json"
+  "fmt"
+  "micrified.com/internal/reload"
+  "net/netip"
+  "os"
+  "slices"
+  "strings"
+  "sync"
+  "time"
+)
+
+const (
+  DefaultInterval = 5 * time.Second
+)
+
+
+/*\
+ *******************************************************************************
+ *                               Definition: Rule                              *
+ *******************************************************************************
+\*/
+
+
+// Rule: Restricts the IPs that may reach a route. An empty route matches all
+// routes, and an empty method list matches all methods. An IP matching any
+// denied range is refused. If any ranges are allowed, an IP matching none of
+// them is refused too. A range is given in CIDR notation, or as a single IP
+type Rule struct {
+  Route   string
+  Methods []string
+  Allow   []string
+  Deny    []string
+}
+
+// rule: A rule with parsed ranges
+type rule struct {
+  route   string
+  methods []string
+  allow   []netip.Prefix
+  deny    []netip.Prefix
+}
+
+// ParsePrefixes parses ranges given in CIDR notation or as single IPs
+func ParsePrefixes (ranges []string) ([]netip.Prefix, error) {
+  prefixes := make([]netip.Prefix, 0, len(ranges))
+  for _, r := range ranges {
+    if !strings.Contains(r, "/") {
+      addr, err := netip.ParseAddr(r)
+      if nil != err {
+        return nil, err
+      }
+      addr = addr.Unmap()
+      prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+      continue
+    }
+    prefix, err := netip.ParsePrefix(r)
+    if nil != err {
+      return nil, err
+    }
+    prefixes = append(prefixes, prefix.Masked())
+  }
+  return prefixes, nil
+}
+
+// contains returns true if any of the prefixes contains the address
+func contains (prefixes []netip.Prefix, addr netip.Addr) bool {
+  return slices.ContainsFunc(prefixes, func (p netip.Prefix) bool {
+    return p.Contains(addr)
+  })
+}
+
+func newRule (r Rule) (rule, error) {
+  allow, err := ParsePrefixes(r.Allow)
+  if nil != err {
+    return rule{}, fmt.Errorf("Bad allowed range for route %q: %w", r.Route, err)
+  }
+  deny, err := ParsePrefixes(r.Deny)
+  if nil != err {
+    return rule{}, fmt.Errorf("Bad denied range for route %q: %w", r.Route, err)
+  }
+  methods := make([]string, len(r.Methods))
+  for i, method := range r.Methods {
+    methods[i] = strings.ToUpper(method)
+  }
+  return rule { route: r.Route, methods: methods, allow: allow, deny: deny }, nil
+}
+
+// applies returns true if the rule applies to the route and method
+func (r *rule) applies (route, method string) bool {
+  return ("" == r.route || route == r.route) &&
+    (0 == len(r.methods) || slices.Contains(r.methods, method))
+}
+
+// permits returns true if the rule does not refuse the address
+func (r *rule) permits (addr netip.Addr) bool {
+  if contains(r.deny, addr) {
+    return false
+  }
+  return 0 == len(r.allow) || contains(r.allow, addr)
+}
+
+
+/*\
+ *******************************************************************************
+ *                             Definition: Service                             *
+ *******************************************************************************
+\*/
+
+
+type Config struct {
+  File     string
+  Interval int
+}
+
+type Service struct {
+  file     string
+  interval time.Duration
+  modified time.Time
+  rules    []rule
+  stop     chan struct{}
+  mutex    sync.RWMutex
+}
+
+// NewService returns a service enforcing the rules in the configured file,
+// which is checked for changes every Interval seconds once started. Without
+// a file, every request is permitted
+func NewService (c Config) (Service, error) {
+  var (
+    interval time.Duration = DefaultInterval
+    modified time.Time     = time.Time{}
+    rules    []rule        = []rule{}
+    err      error         = nil
+  )
+  if c.Interval > 0 {
+    interval = time.Duration(c.Interval) * time.Second
+  }
+  if "" != c.File {
+    if rules, modified, err = load(c.File); nil != err {
+      return Service{}, err
+    }
+  }
+  return Service {
+    file:     c.File,
+    interval: interval,
+    modified: modified,
+    rules:    rules,
+    stop:     make(chan struct{}),
+    mutex:    sync.RWMutex{},
+  }, nil
+}
+
+// load reads and parses the rules file, returning the rules and the time
+// the file was last modified
+func load (file string) ([]rule, time.Time, error) {
+  var (
+    rules  []Rule = []Rule{}
+    parsed []rule = []rule{}
+  )
+  info, err := os.Stat(file)
+  if nil != err {
+    return nil, time.Time{}, fmt.Errorf("Couldn't read access rules %s: %w",
+      file, err)
+  }
+  b, err := os.ReadFile(file)
+  if nil != err {
+    return nil, time.Time{}, fmt.Errorf("Couldn't read access rules %s: %w",
+      file, err)
+  }
+  if err = json.Unmarshal(b, &rules); nil != err {
+    return nil, time.Time{}, fmt.Errorf("Bad access rules %s: %w", file, err)
+  }
+  for _, r := range rules {
+    z, err := newRule(r)
+    if nil != err {
+      return nil, time.Time{}, err
+    }
+    parsed = append(parsed, z)
+  }
+  return parsed, info.ModTime(), nil
+}
+
+// Reload reads the rules file if it changed since it was last read. The
+// rules in use are only replaced if the whole file is valid. It returns
+// true if the rules were replaced
+func (s *Service) Reload () (bool, error) {
+  info, err := os.Stat(s.file)
+  if nil != err {
+    return false, fmt.Errorf("Couldn't read access rules %s: %w", s.file, err)
+  }
+  s.mutex.RLock()
+  unchanged := info.ModTime().Equal(s.modified)
+  s.mutex.RUnlock()
+  if unchanged {
+    return false, nil
+  }
+
+  rules, modified, err := load(s.file)
+  if nil != err {
+    return false, err
+  }
+
+  s.mutex.Lock()
+  defer s.mutex.Unlock()
+  s.rules, s.modified = rules, modified
+  return true, nil
+}
+
+// Start watches the rules file for changes until stopped. Errors are logged,
+// and the rules last read remain in use
+func (s *Service) Start () {
+  if "" == s.file {
+    return
+  }
+  reload.Watch("Access rules", s.file, s.interval, s.stop, s.Reload)
+}
+
+// Stop ends watching the rules file
+func (s *Service) Stop () {
+  close(s.stop)
+}
+
+// Permitted returns true if no rule applying to the route and method refuses
+// the IP. Malformed IPs are refused. It is thread safe
+func (s *Service) Permitted (route, method, ip string) bool {
+  addr, err := netip.ParseAddr(ip)
+  if nil != err {
+    return false
+  }
+  addr = addr.Unmap()
+
+  s.mutex.RLock()
+  defer s.mutex.RUnlock()
+  for i := range s.rules {
+    if s.rules[i].applies(route, method) && !s.rules[i].permits(addr) {
+      return false
+    }
+  }
+  return true
+}
@@ -0,0 +1,142 @@
+package access
+
+import (
+  "encoding/json"
+  "net/http"
+  "os"
+  "path/filepath"
+  "testing"
+  "time"
+)
+
+
+/*\
+ *******************************************************************************
+ *                                   Helpers                                   *
+ *******************************************************************************
+\*/
+
+
+// writeRules writes the rules to the file, modified at the given time
+func writeRules (t *testing.T, file string, rules any, modified time.Time) {
+  b, err := json.Marshal(rules)
+  if nil != err {
+    t.Fatalf("Marshal failed: %v", err)
+  }
+  if err = os.WriteFile(file, b, 0600); nil != err {
+    t.Fatalf("WriteFile failed: %v", err)
+  }
+  if err = os.Chtimes(file, modified, modified); nil != err {
+    t.Fatalf("Chtimes failed: %v", err)
+  }
+}
+
+// newTestService returns a service enforcing the rules, and its rules file
+func newTestService (t *testing.T, rules []Rule) (*Service, string) {
+  file := filepath.Join(t.TempDir(), "access.json")
+  writeRules(t, file, rules, time.Now().Add(-time.Hour))
+  s, err := NewService(Config{ File: file })
+  if nil != err {
+    t.Fatalf("NewService failed: %v", err)
+  }
+  return &s, file
+}
+
+
+/*\
+ *******************************************************************************
+ *                                    Tests                                    *
+ *******************************************************************************
+\*/
+
+
+// TestParsePrefixes verifies that ranges and single IPs are parsed, masked
+// and unmapped, and that malformed ones are refused
+func TestParsePrefixes (t *testing.T) {
+  prefixes, err := ParsePrefixes([]string {
+    "10.1.2.3/8", "192.0.2.1", "::ffff:192.0.2.2", "2001:db8::1/32",
+  })
+  if nil != err {
+    t.Fatalf("ParsePrefixes failed: %v", err)
+  }
+  for i, expected := range []string {
+    "10.0.0.0/8", "192.0.2.1/32", "192.0.2.2/32", "2001:db8::/32",
+  } {
+    if expected != prefixes[i].String() {
+      t.Fatalf("Range %d parsed as %s, expected %s", i, prefixes[i], expected)
+    }
+  }
+  for _, r := range []string { "10.0.0.256", "10.0.0.0/33", "host", "" } {
+    if _, err = ParsePrefixes([]string{ r }); nil == err {
+      t.Fatalf("Range %q parsed", r)
+    }
+  }
+}
+
+// TestPermitted verifies that rules apply to their route and methods only,
+// that denied ranges take precedence, and that allowed ranges exclude others
+func TestPermitted (t *testing.T) {
+  s, _ := newTestService(t, []Rule {
+    { Deny: []string{ "203.0.113.0/24" } },
+    { Route: "/users", Methods: []string{ "put", "DELETE" },
+      Allow: []string{ "10.0.0.0/8" }, Deny: []string{ "10.0.0.1" } },
+  })
+  cases := []struct {
+    Route, Method, IP string
+    Permitted         bool
+  } {
+    { "/blog", http.MethodGet, "192.0.2.1", true },
+    { "/blog", http.MethodGet, "203.0.113.9", false },
+    { "/users", http.MethodGet, "203.0.113.9", false },
+    { "/users", http.MethodGet, "192.0.2.1", true },
+    { "/users", http.MethodPut, "192.0.2.1", false },
+    { "/users", http.MethodPut, "10.2.3.4", true },
+    { "/users", http.MethodDelete, "::ffff:10.2.3.4", true },
+    { "/users", http.MethodDelete, "10.0.0.1", false },
+    { "/users/sessions", http.MethodDelete, "192.0.2.1", true },
+    { "/blog", http.MethodGet, "not an IP", false },
+  }
+  for _, c := range cases {
+    permitted := s.Permitted(c.Route, c.Method, c.IP)
+    if c.Permitted != permitted {
+      t.Fatalf("%s %s from %s permitted: %v", c.Method, c.Route, c.IP,
+        permitted)
+    }
+  }
+
+  // Without rules, everything is permitted
+  none, err := NewService(Config{})
+  if nil != err {
+    t.Fatalf("NewService failed: %v", err)
+  }
+  if !none.Permitted("/users", http.MethodPut, "203.0.113.9") {
+    t.Fatalf("Request refused without rules")
+  }
+}
+
+// TestReload verifies that the rules are replaced when the file changes, and
+// kept if it is unchanged or invalid
+func TestReload (t *testing.T) {
+  deny := []Rule{ { Deny: []string{ "192.0.2.1" } } }
+  s, file := newTestService(t, []Rule{})
+  if ok, err := s.Reload(); nil != err || ok {
+    t.Fatalf("Unchanged rules reloaded (%v)", err)
+  }
+
+  writeRules(t, file, deny, time.Now())
+  if ok, err := s.Reload(); nil != err || !ok {
+    t.Fatalf("Changed rules not reloaded (%v)", err)
+  }
+  if s.Permitted("/blog", http.MethodGet, "192.0.2.1") {
+    t.Fatalf("Reloaded rules not applied")
+  }
+
+  writeRules(t, file, []Rule{ { Deny: []string{ "192.0.2.256" } } },
+    time.Now().Add(time.Second))
+  if ok, err := s.Reload(); nil == err || ok {
+    t.Fatalf("Invalid rules reloaded")
+  }
+  if s.Permitted("/blog", http.MethodGet, "192.0.2.1") {
+    t.Fatalf("Rules in use replaced by invalid rules")
+  }
+}
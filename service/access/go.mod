@@ -0,0 +1,7 @@
+module micrified.com/service/access
+
+replace micrified.com/internal/reload => ../../internal/reload
+
+go 1.22.3
+
+require micrified.com/internal/reload v0.0.0-00010101000000-000000000000
@@ -0,0 +1,68 @@
+package auth
+
+import (
+  "crypto/rand"
+  "encoding/hex"
+  "slices"
+  "strings"
+)
+
+const (
+  APIKeyScheme     = "mk"
+  APIKeyPrefixSize = 4
+  APIKeySecretSize = 32
+)
+
+
+/*\
+ *******************************************************************************
+ *                            Definition: API keys                             *
+ *******************************************************************************
+\*/
+
+
+// NewAPIKey returns a new API key of the form mk_<prefix>_<secret>, with its
+// prefix and the (hash, salt) pair of its secret. The prefix identifies the
+// key, and may be stored and shown in the clear. The secret is never stored
+func NewAPIKey () (key, prefix string, hash, salt Hash, err error) {
+  b := make([]byte, APIKeyPrefixSize + APIKeySecretSize)
+  if _, err = rand.Read(b); nil != err {
+    return
+  }
+  prefix = hex.EncodeToString(b[:APIKeyPrefixSize])
+  secret := hex.EncodeToString(b[APIKeyPrefixSize:])
+  if hash, salt, err = NewSecret(secret); nil != err {
+    return
+  }
+  key = APIKeyScheme + "_" + prefix + "_" + secret
+  return
+}
+
+// ParseAPIKey splits an API key into its prefix and secret
+func ParseAPIKey (key string) (prefix, secret string, ok bool) {
+  parts := strings.Split(key, "_")
+  if 3 != len(parts) || APIKeyScheme != parts[0] {
+    return "", "", false
+  }
+  if 2 * APIKeyPrefixSize != len(parts[1]) || "" == parts[2] {
+    return "", "", false
+  }
+  return parts[1], parts[2], true
+}
+
+// IsAPIKey returns true if the bearer token has the form of an API key
+func IsAPIKey (token string) bool {
+  _, _, ok := ParseAPIKey(token)
+  return ok
+}
+
+// Intersect returns the permissions granted in both a and b
+func Intersect (a, b []string) []string {
+  permissions := []string{}
+  for _, p := range a {
+    if slices.Contains(b, p) {
+      permissions = append(permissions, p)
+    }
+  }
+  return permissions
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+  "slices"
+  "strings"
+  "testing"
+)
+
+
+/*\
+ *******************************************************************************
+ *                                    Tests                                    *
+ *******************************************************************************
+\*/
+
+
+// TestNewAPIKey verifies that new keys parse into their prefix and a secret
+// matching the hash returned
+func TestNewAPIKey (t *testing.T) {
+  key, prefix, hash, salt, err := NewAPIKey()
+  if nil != err {
+    t.Fatalf("NewAPIKey failed: %v", err)
+  }
+  p, secret, ok := ParseAPIKey(key)
+  if !ok || prefix != p {
+    t.Fatalf("Key %q parsed with prefix %q (%v), expected %q", key, p, ok,
+      prefix)
+  }
+  if !Compare(secret, salt[:], hash[:]) {
+    t.Fatalf("Secret does not match its hash")
+  }
+  if other, _, _, _, _ := NewAPIKey(); key == other {
+    t.Fatalf("Keys repeated")
+  }
+}
+
+// TestParseAPIKey verifies that only tokens of the form mk_<prefix>_<secret>
+// are taken for API keys
+func TestParseAPIKey (t *testing.T) {
+  prefix := strings.Repeat("a", 2 * APIKeyPrefixSize)
+  if p, s, ok := ParseAPIKey("mk_" + prefix + "_secret"); !ok ||
+    prefix != p || "secret" != s {
+    t.Fatalf("Key parsed as %q, %q (%v)", p, s, ok)
+  }
+  for _, token := range []string {
+    "",
+    "mk_" + prefix,
+    "mk_" + prefix + "_",
+    "mk_" + prefix + "_secret_more",
+    "xx_" + prefix + "_secret",
+    "mk_" + prefix[1:] + "_secret",
+    "eyJhbGciOiJIUzI1NiJ9.e30.sig",
+  } {
+    if IsAPIKey(token) {
+      t.Fatalf("Token %q taken for an API key", token)
+    }
+  }
+}
+
+// TestIntersect verifies that only permissions granted by both are kept, in
+// the order of the first
+func TestIntersect (t *testing.T) {
+  cases := []struct {
+    A, B, Expected []string
+  } {
+    { []string{ PermissionBlogWrite, PermissionUsersManage },
+      []string{ PermissionUsersManage, PermissionBlogWrite },
+      []string{ PermissionBlogWrite, PermissionUsersManage } },
+    { []string{ PermissionBlogWrite, PermissionUsersManage },
+      []string{ PermissionBlogWriteOwn, PermissionUsersManage },
+      []string{ PermissionUsersManage } },
+    { []string{ PermissionBlogWrite }, nil, []string{} },
+    { nil, []string{ PermissionBlogWrite }, []string{} },
+  }
+  for _, c := range cases {
+    if p := Intersect(c.A, c.B); !slices.Equal(c.Expected, p) {
+      t.Fatalf("%v and %v gave %v, expected %v", c.A, c.B, p, c.Expected)
+    }
+  }
+}
@@ -12,17 +12,20 @@ package auth
 
 import (
   "bytes"
+  "context"
   "crypto/rand"
   "encoding/hex"
   "fmt"
   "golang.org/x/crypto/sha3"
+  "log"
   "strconv"
   "sync"
   "time"
 )
 
 const (
-  HashSize = 64
+  HashSize      = 64
+  PruneInterval = 10 * time.Minute
 )
 
 
@@ -96,17 +99,30 @@ func ToSecret (digest string, salt Hash) (secret Hash) {
 
 
 type Config struct {
-  Base   int
-  Factor int
-  Limit  int
-  Retry  int
+  Base     int
+  Factor   int
+  Limit    int
+  Retry    int
+  Store    string
+  Cookie   string
+  Binding  string
+  Rebind   bool
+  Idle     int
+  Lifetime int
+  Tokens   TokenConfig
 }
 
 type Service struct {
   config     Config
-  penalties  SyncMap[string, Penalty]
+  keys       keySet
+  penalties  PenaltyStore
+  lockouts   PenaltyStore
   sessions   SyncMap[string, Session]
+  owners     SyncMap[string, string]
+  grants     GrantStore
+  challenges SyncMap[string, Challenge]
   mutex      sync.Mutex
+  stop       chan struct{}
 }
 
 func NewService (c Config) (Service, error) {
@@ -119,39 +135,114 @@ func NewService (c Config) (Service, error) {
   if c.Factor < 1 {
     return Service{}, fmt.Errorf("Unmet condition: 1 < Factor")
   }
+  if "" != c.Store && StoreMemory != c.Store && StoreSQL != c.Store {
+    return Service{}, fmt.Errorf("Unknown penalty store %q", c.Store)
+  }
+  if c.Idle < 0 || c.Lifetime < 0 {
+    return Service{}, fmt.Errorf("Unmet condition: Idle >= 0, Lifetime >= 0")
+  }
+  if !validBinding(c.Binding) {
+    return Service{}, fmt.Errorf("Unknown session binding %q", c.Binding)
+  }
+  keys, err := newKeySet(&c.Tokens)
+  if nil != err {
+    return Service{}, err
+  }
+  if len(keys.keys) > 0 && (c.Tokens.Access < 1 || c.Tokens.Refresh < 1) {
+    return Service{}, fmt.Errorf("Unmet condition: Access >= 1, Refresh >= 1")
+  }
   return Service {
     config:     c,
-    penalties:  NewSyncMap[string, Penalty](),
+    keys:       keys,
+    penalties:  NewMemoryPenaltyStore(),
+    lockouts:   NewMemoryPenaltyStore(),
     sessions:   NewSyncMap[string, Session](),
+    owners:     NewSyncMap[string, string](),
+    grants:     NewMemoryGrantStore(),
+    challenges: NewSyncMap[string, Challenge](),
     mutex:      sync.Mutex{},
+    stop:       make(chan struct{}),
   }, nil
 }
 
+// idle returns the longest period a session may be idle for
+func (s *Service) idle () time.Duration {
+  if s.config.Idle > 0 {
+    return time.Duration(s.config.Idle) * time.Second
+  }
+  return MaxSessionPeriod
+}
+
+// lifetime returns the longest a session may last for, however active
+func (s *Service) lifetime () time.Duration {
+  if s.config.Lifetime > 0 {
+    return time.Duration(s.config.Lifetime) * time.Second
+  }
+  return SessionLifetime
+}
+
+// Cookie returns the name of the session cookie, or the empty string if
+// session cookies are disabled
+func (s *Service) Cookie () string {
+  return s.config.Cookie
+}
+
 // Penalised returns true if the given IP has an assigned penalty
 // The method is thread safe
-func (s *Service) Penalised (ip string) bool {
-  if penalty, ok := s.penalties.Get(ip); ok {
-    return time.Now().Before(penalty.Deadline)
-  }
-  return false
+func (s *Service) Penalised (x context.Context, ip string) bool {
+  return s.penalised(x, s.penalties, ip)
 }
 
 // Penalise installs or refreshes a penalty for the given IP
-func (s *Service) Penalise (ip string) {
-  fmt.Println("Penalise()")
-  if penalty, ok := s.penalties.Get(ip); ok {
-    fmt.Printf("Old penalty: %+v\n", penalty)
-    updated := penalty.Refresh(&s.config)
-    fmt.Printf("New penalty: %+v\n", updated)
-    s.penalties.Put(ip, updated)
-  } else {
-    s.penalties.Put(ip, NewPenalty(&s.config))
-  }
+func (s *Service) Penalise (x context.Context, ip string) {
+  s.penalise(x, s.penalties, ip)
 }
 
 // NoPenalty removes any registered penalty for the given IP
-func (s *Service) NoPenalty (ip string) {
-  s.penalties.Delete(ip)
+func (s *Service) NoPenalty (x context.Context, ip string) {
+  s.pardon(x, s.penalties, ip)
+}
+
+// Penalties returns all registered penalties by IP (or other key)
+func (s *Service) Penalties (x context.Context) (map[string]Penalty, error) {
+  return s.penalties.List(x)
+}
+
+// AccountPenalised returns true if the given username is locked out. Failures
+// are tracked per username independently of the IP they originate from, so
+// that attempts spread over many IPs are slowed too. It is thread safe
+func (s *Service) AccountPenalised (x context.Context, username string) bool {
+  return s.penalised(x, s.lockouts, username)
+}
+
+// PenaliseAccount installs or refreshes a lockout for the given username
+func (s *Service) PenaliseAccount (x context.Context, username string) {
+  s.penalise(x, s.lockouts, username)
+}
+
+// NoAccountPenalty removes any lockout for the given username
+func (s *Service) NoAccountPenalty (x context.Context, username string) {
+  s.pardon(x, s.lockouts, username)
+}
+
+// RetryAfter returns the time remaining until neither the IP penalty nor the
+// lockout of the username apply. It is zero if neither applies
+func (s *Service) RetryAfter (x context.Context, ip, username string) time.Duration {
+  return max(0, s.remaining(x, s.penalties, ip),
+    s.remaining(x, s.lockouts, username))
+}
+
+// UsePenaltyStores replaces the stores keeping IP penalties and account
+// lockouts (held in memory by default). It must be called before the service
+// is in use
+func (s *Service) UsePenaltyStores (penalties, lockouts PenaltyStore) {
+  s.penalties, s.lockouts = penalties, lockouts
+}
+
+// UseGrantStore replaces the store keeping refresh tokens and revocations
+// (held in memory by default). It must be called before the service is in use
+func (s *Service) UseGrantStore (grants GrantStore) {
+  s.grants = grants
 }
 
 // Compare returns true if hash(digest, salt) == hash
@@ -175,7 +266,7 @@ type AuthFunc func () (bool, error)
 // If the authentication function returns (true, nil), then a new session is
 // created and returned by value. Otherwise, a default session is returned
 // and the returned values of the authentication function propagated back
-func (s *Service) Authenticate (ip, username, period string, f AuthFunc) (Session, bool, error) {
+func (s *Service) Authenticate (c Client, username, period string, f AuthFunc) (Session, bool, error) {
   var (
     z   Session = Session{}
     ok  bool    = false
@@ -192,41 +283,104 @@ func (s *Service) Authenticate (ip, username, period string, f AuthFunc) (Sessio
   }
 
   // Parse session period; apply limits
-  t := MaxSessionPeriod
+  t := s.idle()
   if value, err := strconv.Atoi(period); nil == err {
-    t = max(min(time.Duration(value) * time.Second, MaxSessionPeriod), 
+    t = max(min(time.Duration(value) * time.Second, s.idle()), 
       MinSessionPeriod)
   }
   fmt.Printf("Duration is %v\n", t)
 
-  // Create session; register if no error
-  if z, err = NewSession(ip, t); nil == err {
+  // Create session; register if no error (replacing any previous session)
+  if z, err = NewSession(c, t, s.lifetime()); nil == err {
     fmt.Printf("Now is %v\n", time.Now().UTC())
     fmt.Printf("Session expires at: %v\n", z.Expiration)
+    if old, ok := s.sessions.Get(username); ok {
+      s.owners.Delete(old.Secret.HexString())
+    }
     s.sessions.Put(username, z)
+    s.owners.Put(z.Secret.HexString(), username)
   }
 
   return z, ok, err
 }
 
 // Deauthenticate checks whether a session exists for the given username
-// and removes the associated session, if so. It is thread safe.
+// and removes the associated session, if so. Any refresh tokens issued to
+// the username are revoked. It is thread safe.
 // Note: This function assumes the invoking request is authenticated,
 // but does not verify. Ensure Authenticate has been performed first for
 // the request!
-func (s *Service) Deauthenticate (username string) error {
-
-  // No mutex holding needed (single access to delete on atomic map)
+func (s *Service) Deauthenticate (x context.Context, username string) error {
 
-  // Delete (assumed username exists);
+  // Secure mutual exclusion (session and owner must be removed together)
+  s.mutex.Lock()
+  if z, ok := s.sessions.Get(username); ok {
+    s.owners.Delete(z.Secret.HexString())
+  }
   s.sessions.Delete(username)
+  s.mutex.Unlock()
 
-  return nil
+  return s.grants.Forget(x, username)
 }
 
-// Authorized checks the provided session secret and checks whether it exists
-// and not expired. It is thread-safe
-func (s *Service) Authorized (ip, username, secret string) error {
+// Revoke forcibly ends everything granting the username access: the session,
+// refresh tokens and pending login challenges are removed, and signed access
+// tokens issued before now are no longer accepted. It is thread safe
+func (s *Service) Revoke (x context.Context, username string) error {
+  s.challenges.DeleteFunc(func (_ string, z Challenge) bool {
+    return username == z.Username
+  })
+  if err := s.grants.Revoke(x, username, time.Now().UTC()); nil != err {
+    return err
+  }
+  return s.Deauthenticate(x, username)
+}
+
+// Start prunes expired refresh tokens, and revocations no unexpired access
+// token predates, every PruneInterval until stopped. Errors are logged
+func (s *Service) Start () {
+  go func () {
+    ticker := time.NewTicker(PruneInterval)
+    defer ticker.Stop()
+    for {
+      select {
+      case <-s.stop:
+        return
+      case <-ticker.C:
+        if err := s.prune(); nil != err {
+          log.Printf("Grant store: %v\n", err)
+        }
+      }
+    }
+  }()
+}
+
+// prune removes grant state that can no longer apply
+func (s *Service) prune () error {
+  x, cancel := context.WithTimeout(context.Background(), StoreTimeout)
+  defer cancel()
+  now := time.Now().UTC()
+  access := time.Duration(s.config.Tokens.Access) * time.Second
+  return s.grants.Prune(x, now, now.Add(-access))
+}
+
+// Stop ends all sessions and pending login challenges, so that no secrets
+// outlive the service, and stops pruning. Refresh tokens are left to the
+// grant store. It is called once requests are drained
+func (s *Service) Stop () {
+  close(s.stop)
+  s.mutex.Lock()
+  defer s.mutex.Unlock()
+  s.sessions.DeleteFunc(func (string, Session) bool { return true })
+  s.owners.DeleteFunc(func (string, string) bool { return true })
+  s.challenges.DeleteFunc(func (string, Challenge) bool { return true })
+}
+
+// Authorized checks the provided session secret and checks whether it exists,
+// is not expired, and is bound to the client as per the binding policy. If
+// rebinding is enabled, a session bound to another client is refused with
+// ErrRebind. It is thread-safe
+func (s *Service) Authorized (c Client, username, secret string) error {
   var z Session
 
   // Grab mutex and lock (need continuous mutual exclusion until renew)
@@ -244,11 +398,6 @@ func (s *Service) Authorized (ip, username, secret string) error {
     return fmt.Errorf("Session secret mismatch")
   }
   
-  // Case: The IP doesn't match that used to create the session
-  if ip != z.IP {
-    return fmt.Errorf("Session IP mismatch")
-  }
-
   // Case: The secret has expired
   fmt.Printf("The session expiration is: %v\n", z.Expiration)
   fmt.Printf("The current time is: %v\n", time.Now().UTC())
@@ -257,8 +406,94 @@ func (s *Service) Authorized (ip, username, secret string) error {
     return fmt.Errorf("Session expired")
   }
 
+  // Case: The client doesn't match that bound to the session
+  if !bound(s.config.Binding, &z, c) {
+    if s.config.Rebind {
+      return ErrRebind
+    }
+    return fmt.Errorf("Session client mismatch")
+  }
+
   // Renew session validity
   s.sessions.Put(username, z.Renew())
 
   return nil
 }
+
+// Identity: The holder of a verified bearer token. Signed access tokens carry
+// the permissions granted at login as scopes. API keys carry the permissions
+// they were issued with (as far as still granted to the owner)
+type Identity struct {
+  Username string
+  Scopes   []string
+  Signed   bool
+  Key      bool
+}
+
+// Identify resolves a bearer token to the username owning the session, and
+// then checks the session is authorized as per Authorized. Signed access
+// tokens are instead verified and resolved to their subject. It is thread-safe
+func (s *Service) Identify (x context.Context, c Client,
+  token string) (Identity, error) {
+  if s.Stateless() && Signed(token) {
+    claims, err := s.Verify(x, token)
+    if nil != err {
+      return Identity{}, err
+    }
+    return Identity {
+      Username: claims.Subject,
+      Scopes:   claims.Scopes,
+      Signed:   true,
+    }, nil
+  }
+  username, ok := s.owners.Get(token)
+  if !ok {
+    return Identity{}, fmt.Errorf("No session for token")
+  }
+  if err := s.Authorized(c, username, token); nil != err {
+    return Identity{}, err
+  }
+  return Identity { Username: username }, nil
+}
+
+// Rebinding returns true if sessions bound to another client may be rebound
+// by re-authenticating
+func (s *Service) Rebinding () bool {
+  return s.config.Rebind
+}
+
+// Owner returns the username owning the session of the token
+func (s *Service) Owner (token string) (string, bool) {
+  return s.owners.Get(token)
+}
+
+// Rebind binds the live session of the token to the client, if the owner
+// (named by username) re-authenticates with the given routine. The session
+// secret is kept, and its validity renewed. It returns the session and true
+// if successful. It is thread-safe
+func (s *Service) Rebind (c Client, username, token string, f AuthFunc) (Session, bool, error) {
+  s.mutex.Lock()
+  defer s.mutex.Unlock()
+
+  // Case: Rebinding disabled, or no such live session
+  if !s.config.Rebind {
+    return Session{}, false, nil
+  }
+  if owner, ok := s.owners.Get(token); !ok || owner != username {
+    return Session{}, false, nil
+  }
+  z, ok := s.sessions.Get(username)
+  if !ok || z.Expired() {
+    return Session{}, false, nil
+  }
+
+  // Case: error during auth or bad credentials
+  if ok, err := f(); nil != err || !ok {
+    return Session{}, ok, err
+  }
+
+  z = z.Renew()
+  z.IP, z.Agent = c.IP, Fingerprint(c.Agent)
+  s.sessions.Put(username, z)
+  return z, true, nil
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+  "encoding/hex"
+  "errors"
+  "golang.org/x/crypto/sha3"
+  "net/netip"
+)
+
+const (
+  BindIP     = "ip"
+  BindPrefix = "prefix"
+  BindAgent  = "agent"
+  BindNone   = "none"
+)
+
+const (
+  PrefixBits4 = 24
+  PrefixBits6 = 64
+)
+
+// ErrRebind: The session is live, but bound to another client. The holder
+// may rebind it by re-authenticating
+var ErrRebind = errors.New("Session bound to another client (re-authenticate)")
+
+
+/*\
+ *******************************************************************************
+ *                          Definition: Session binding                        *
+ *******************************************************************************
+\*/
+
+
+// Client: The origin of a request, to which sessions are bound
+type Client struct {
+  IP    string
+  Agent string
+}
+
+// Fingerprint returns the fingerprint of a user agent
+func Fingerprint (agent string) string {
+  digest := sha3.Sum256([]byte(agent))
+  return hex.EncodeToString(digest[:])
+}
+
+// validBinding returns true if the binding policy exists. The empty policy
+// is strict IP binding
+func validBinding (policy string) bool {
+  switch policy {
+  case "", BindIP, BindPrefix, BindAgent, BindNone:
+    return true
+  }
+  return false
+}
+
+// bound returns true if the client satisfies the binding of the session
+// under the given policy
+func bound (policy string, z *Session, c Client) bool {
+  switch policy {
+  case BindNone:
+    return true
+  case BindPrefix:
+    return samePrefix(z.IP, c.IP)
+  case BindAgent:
+    return z.Agent == Fingerprint(c.Agent)
+  }
+  return z.IP == c.IP
+}
+
+// samePrefix returns true if both IPs are in the same /24 (IPv4) or /64
+// (IPv6) network
+func samePrefix (a, b string) bool {
+  x, err := netip.ParseAddr(a)
+  if nil != err {
+    return false
+  }
+  y, err := netip.ParseAddr(b)
+  if nil != err {
+    return false
+  }
+  x, y = x.Unmap(), y.Unmap()
+  if x.Is4() != y.Is4() {
+    return false
+  }
+  bits := PrefixBits6
+  if x.Is4() {
+    bits = PrefixBits4
+  }
+  prefix, err := x.Prefix(bits)
+  return nil == err && prefix.Contains(y)
+}
@@ -0,0 +1,158 @@
+package auth
+
+import (
+  "errors"
+  "testing"
+  "time"
+)
+
+
+/*\
+ *******************************************************************************
+ *                                   Helpers                                   *
+ *******************************************************************************
+\*/
+
+
+func newBindingService (t *testing.T, policy string, rebind bool) *Service {
+  s, err := NewService(Config {
+    Base: 1, Factor: 2, Limit: 8, Retry: 3, Binding: policy, Rebind: rebind,
+  })
+  if nil != err {
+    t.Fatalf("NewService failed: %v", err)
+  }
+  return &s
+}
+
+// authenticate opens a session of the test user for the client, returning
+// its token
+func authenticate (t *testing.T, s *Service, c Client) string {
+  z, ok, err := s.Authenticate(c, TestUsername, "", accept)
+  if nil != err || !ok {
+    t.Fatalf("Authenticate failed: %v", err)
+  }
+  return z.Secret.HexString()
+}
+
+// refuse is an authentication routine refusing the credentials
+func refuse () (bool, error) {
+  return false, nil
+}
+
+// accept is an authentication routine accepting the credentials
+func accept () (bool, error) {
+  return true, nil
+}
+
+
+/*\
+ *******************************************************************************
+ *                                    Tests                                    *
+ *******************************************************************************
+\*/
+
+
+// TestSamePrefix verifies that IPs are compared by their /24 (IPv4) or /64
+// (IPv6) network, and that mapped IPv4 addresses compare as IPv4
+func TestSamePrefix (t *testing.T) {
+  cases := []struct {
+    A, B string
+    Same bool
+  } {
+    { "192.0.2.1", "192.0.2.254", true },
+    { "192.0.2.1", "192.0.3.1", false },
+    { "::ffff:192.0.2.1", "192.0.2.9", true },
+    { "2001:db8::1", "2001:db8::ffff:1", true },
+    { "2001:db8::1", "2001:db8:0:1::1", false },
+    { "192.0.2.1", "2001:db8::1", false },
+    { "192.0.2.1", "", false },
+    { "host", "host", false },
+  }
+  for _, c := range cases {
+    if same := samePrefix(c.A, c.B); c.Same != same {
+      t.Fatalf("%q and %q in the same network: %v", c.A, c.B, same)
+    }
+  }
+}
+
+// TestBound verifies which clients satisfy the binding of a session under
+// each policy
+func TestBound (t *testing.T) {
+  z, err := NewSession(Client{ IP: "192.0.2.1", Agent: "browser" },
+    time.Minute, time.Hour)
+  if nil != err {
+    t.Fatalf("NewSession failed: %v", err)
+  }
+  var (
+    same   Client = Client{ IP: "192.0.2.1", Agent: "browser" }
+    nearby Client = Client{ IP: "192.0.2.2", Agent: "browser" }
+    agent  Client = Client{ IP: "192.0.2.1", Agent: "other" }
+    far    Client = Client{ IP: "198.51.100.1", Agent: "browser" }
+  )
+  cases := []struct {
+    Policy string
+    Client Client
+    Bound  bool
+  } {
+    { "", same, true },
+    { "", nearby, false },
+    { BindIP, agent, true },
+    { BindIP, far, false },
+    { BindPrefix, nearby, true },
+    { BindPrefix, far, false },
+    { BindAgent, far, true },
+    { BindAgent, agent, false },
+    { BindNone, Client{}, true },
+  }
+  for _, c := range cases {
+    if ok := bound(c.Policy, &z, c.Client); c.Bound != ok {
+      t.Fatalf("Client %+v bound under %q: %v", c.Client, c.Policy, ok)
+    }
+  }
+}
+
+// TestRebind verifies that sessions used from another client may only be
+// rebound when enabled, by re-authenticating, and that the session then
+// moves to the new client
+func TestRebind (t *testing.T) {
+  var (
+    first  Client = Client{ IP: "192.0.2.1", Agent: "browser" }
+    second Client = Client{ IP: "198.51.100.1", Agent: "browser" }
+  )
+
+  // Disabled: the session is refused, and cannot be rebound
+  s := newBindingService(t, BindIP, false)
+  token := authenticate(t, s, first)
+  if err := s.Authorized(second, TestUsername, token); nil == err ||
+    errors.Is(err, ErrRebind) {
+    t.Fatalf("Other client gave %v, expected a mismatch", err)
+  }
+  if _, ok, _ := s.Rebind(second, TestUsername, token, accept); ok {
+    t.Fatalf("Session rebound while rebinding is disabled")
+  }
+
+  // Enabled: the session is only rebound with the credentials
+  s = newBindingService(t, BindIP, true)
+  token = authenticate(t, s, first)
+  if err := s.Authorized(second, TestUsername, token); !errors.Is(err,
+    ErrRebind) {
+    t.Fatalf("Other client gave %v, expected %v", err, ErrRebind)
+  }
+  if _, ok, _ := s.Rebind(second, TestUsername, token, refuse); ok {
+    t.Fatalf("Session rebound with bad credentials")
+  }
+  if _, ok, _ := s.Rebind(second, "other", token, accept); ok {
+    t.Fatalf("Session rebound by another user")
+  }
+  z, ok, err := s.Rebind(second, TestUsername, token, accept)
+  if nil != err || !ok || token != z.Secret.HexString() {
+    t.Fatalf("Rebind failed (%v, %v), or changed the secret", ok, err)
+  }
+  if err = s.Authorized(second, TestUsername, token); nil != err {
+    t.Fatalf("Rebound client refused: %v", err)
+  }
+  if err = s.Authorized(first, TestUsername, token); !errors.Is(err,
+    ErrRebind) {
+    t.Fatalf("Former client gave %v, expected %v", err, ErrRebind)
+  }
+}
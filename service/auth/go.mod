@@ -2,6 +2,9 @@ module micrified.com/service/auth
 
 go 1.22.3
 
-require golang.org/x/crypto v0.23.0
+require (
+	github.com/DATA-DOG/go-sqlmock v1.5.2
+	golang.org/x/crypto v0.23.0
+)
 
 require golang.org/x/sys v0.20.0 // indirect
@@ -0,0 +1,177 @@
+package auth
+
+import (
+  "context"
+  "database/sql"
+  "fmt"
+  "time"
+)
+
+
+/*\
+ *******************************************************************************
+ *                           Definition: Grant stores                          *
+ *******************************************************************************
+\*/
+
+
+// GrantStore: Keeps the state behind token grants: the refresh tokens issued
+// (by digest), and the time each user's tokens were last revoked.
+// Implementations must be thread safe. Take removes the refresh token as it
+// returns it, and must do so atomically, so that a token is redeemed once.
+// Prune removes refresh tokens expired by the first time, and revocations
+// made before the second
+type GrantStore interface {
+  Put(context.Context, string, Refresh) error
+  Take(context.Context, string) (Refresh, bool, error)
+  Forget(context.Context, string) error
+  Revoke(context.Context, string, time.Time) error
+  Revoked(context.Context, string) (time.Time, bool, error)
+  Prune(context.Context, time.Time, time.Time) error
+}
+
+// MemoryGrantStore: Keeps grant state in process memory. It is lost when the
+// process exits
+type MemoryGrantStore struct {
+  refreshes SyncMap[string, Refresh]
+  revoked   SyncMap[string, time.Time]
+}
+
+func NewMemoryGrantStore () *MemoryGrantStore {
+  return &MemoryGrantStore {
+    refreshes: NewSyncMap[string, Refresh](),
+    revoked:   NewSyncMap[string, time.Time](),
+  }
+}
+
+func (m *MemoryGrantStore) Put (x context.Context, key string, r Refresh) error {
+  m.refreshes.Put(key, r)
+  return nil
+}
+
+func (m *MemoryGrantStore) Take (x context.Context, key string) (Refresh, bool, error) {
+  r, ok := m.refreshes.Take(key)
+  return r, ok, nil
+}
+
+func (m *MemoryGrantStore) Forget (x context.Context, username string) error {
+  m.refreshes.DeleteFunc(func (_ string, r Refresh) bool {
+    return username == r.Username
+  })
+  return nil
+}
+
+func (m *MemoryGrantStore) Revoke (x context.Context, username string,
+  t time.Time) error {
+  m.revoked.Update(username, func (last time.Time, _ bool) time.Time {
+    return maxTime(last, t)
+  })
+  return nil
+}
+
+func (m *MemoryGrantStore) Revoked (x context.Context, username string) (time.Time, bool, error) {
+  t, ok := m.revoked.Get(username)
+  return t, ok, nil
+}
+
+func (m *MemoryGrantStore) Prune (x context.Context, expired,
+  revoked time.Time) error {
+  m.refreshes.DeleteFunc(func (_ string, r Refresh) bool {
+    return !r.Expiration.After(expired)
+  })
+  m.revoked.DeleteFunc(func (_ string, t time.Time) bool {
+    return t.Before(revoked)
+  })
+  return nil
+}
+
+// SQLGrantStore: Keeps grant state in database tables, so that it survives
+// restarts and is shared by all processes using the database. Refresh tokens
+// are kept by digest, with the username and expiration. Revocations are kept
+// by username. Times are in Unix milliseconds
+type SQLGrantStore struct {
+  db                 *sql.DB
+  refreshes, revoked string
+}
+
+func NewSQLGrantStore (db *sql.DB, refreshes, revoked string) *SQLGrantStore {
+  return &SQLGrantStore { db: db, refreshes: refreshes, revoked: revoked }
+}
+
+func (s *SQLGrantStore) Put (x context.Context, key string, r Refresh) error {
+  q := fmt.Sprintf("INSERT INTO %s (id, username, expiration) VALUES (?,?,?)",
+    s.refreshes)
+  _, err := s.db.ExecContext(x, q, key, r.Username, r.Expiration.UnixMilli())
+  return err
+}
+
+// Take reads and deletes the token in one transaction, holding the row lock,
+// so that concurrent redemptions of the same token find it once
+func (s *SQLGrantStore) Take (x context.Context, key string) (Refresh, bool, error) {
+  var (
+    expiration int64
+    r          Refresh
+  )
+
+  t, err := s.db.BeginTx(x, nil)
+  if nil != err {
+    return Refresh{}, false, err
+  }
+  defer t.Rollback() // Has no effect if transaction succeeds
+
+  q := fmt.Sprintf("SELECT username, expiration FROM %s WHERE id = ? " +
+    "FOR UPDATE", s.refreshes)
+  err = t.QueryRowContext(x, q, key).Scan(&r.Username, &expiration)
+  if sql.ErrNoRows == err {
+    return Refresh{}, false, nil
+  } else if nil != err {
+    return Refresh{}, false, err
+  }
+  q = fmt.Sprintf("DELETE FROM %s WHERE id = ?", s.refreshes)
+  if _, err = t.ExecContext(x, q, key); nil != err {
+    return Refresh{}, false, err
+  }
+  if err = t.Commit(); nil != err {
+    return Refresh{}, false, err
+  }
+  r.Expiration = time.UnixMilli(expiration).UTC()
+  return r, true, nil
+}
+
+func (s *SQLGrantStore) Forget (x context.Context, username string) error {
+  q := fmt.Sprintf("DELETE FROM %s WHERE username = ?", s.refreshes)
+  _, err := s.db.ExecContext(x, q, username)
+  return err
+}
+
+// Revoke records the revocation time, which only ever moves later
+func (s *SQLGrantStore) Revoke (x context.Context, username string,
+  t time.Time) error {
+  q := fmt.Sprintf("INSERT INTO %s (username, time) VALUES (?,?) " +
+    "ON DUPLICATE KEY UPDATE time = GREATEST(time, ?)", s.revoked)
+  _, err := s.db.ExecContext(x, q, username, t.UnixMilli(), t.UnixMilli())
+  return err
+}
+
+func (s *SQLGrantStore) Revoked (x context.Context, username string) (time.Time, bool, error) {
+  var t int64
+  q := fmt.Sprintf("SELECT time FROM %s WHERE username = ?", s.revoked)
+  err := s.db.QueryRowContext(x, q, username).Scan(&t)
+  if sql.ErrNoRows == err {
+    return time.Time{}, false, nil
+  } else if nil != err {
+    return time.Time{}, false, err
+  }
+  return time.UnixMilli(t).UTC(), true, nil
+}
+
+func (s *SQLGrantStore) Prune (x context.Context, expired,
+  revoked time.Time) error {
+  q := fmt.Sprintf("DELETE FROM %s WHERE expiration <= ?", s.refreshes)
+  if _, err := s.db.ExecContext(x, q, expired.UnixMilli()); nil != err {
+    return err
+  }
+  q = fmt.Sprintf("DELETE FROM %s WHERE time < ?", s.revoked)
+  _, err := s.db.ExecContext(x, q, revoked.UnixMilli())
+  return err
+}
@@ -0,0 +1,199 @@
+package auth
+
+import (
+  "context"
+  "database/sql"
+  "fmt"
+  "log"
+  "time"
+)
+
+const (
+  StoreMemory = "memory"
+  StoreSQL    = "sql"
+)
+
+// StoreTimeout: Limit on recording a failure outliving its request
+const StoreTimeout = 5 * time.Second
+
+
+/*\
+ *******************************************************************************
+ *                          Definition: Penalty stores                         *
+ *******************************************************************************
+\*/
+
+
+// PenaltyStore: Keeps penalties by key (e.g. IP address or username).
+// Implementations must be thread safe. Fail counts one more failure for the
+// key, and must do so atomically, so that concurrent failures all count
+type PenaltyStore interface {
+  Get(context.Context, string) (Penalty, bool, error)
+  Fail(context.Context, string, *Config) error
+  Delete(context.Context, string) error
+  List(context.Context) (map[string]Penalty, error)
+}
+
+// MemoryPenaltyStore: Keeps penalties in process memory. They are lost when
+// the process exits
+type MemoryPenaltyStore struct {
+  penalties SyncMap[string, Penalty]
+}
+
+func NewMemoryPenaltyStore () *MemoryPenaltyStore {
+  return &MemoryPenaltyStore { penalties: NewSyncMap[string, Penalty]() }
+}
+
+func (m *MemoryPenaltyStore) Get (x context.Context, key string) (Penalty, bool, error) {
+  penalty, ok := m.penalties.Get(key)
+  return penalty, ok, nil
+}
+
+func (m *MemoryPenaltyStore) Fail (x context.Context, key string, c *Config) error {
+  m.penalties.Update(key, func (penalty Penalty, _ bool) Penalty {
+    return penalty.Failed(c)
+  })
+  return nil
+}
+
+func (m *MemoryPenaltyStore) Delete (x context.Context, key string) error {
+  m.penalties.Delete(key)
+  return nil
+}
+
+func (m *MemoryPenaltyStore) List (x context.Context) (map[string]Penalty, error) {
+  return m.penalties.Copy(), nil
+}
+
+// SQLPenaltyStore: Keeps penalties in a database table, so that they survive
+// restarts and are shared by all processes using the database. The table
+// holds the key, the deadline (in Unix milliseconds) and the count
+type SQLPenaltyStore struct {
+  db    *sql.DB
+  table string
+}
+
+func NewSQLPenaltyStore (db *sql.DB, table string) *SQLPenaltyStore {
+  return &SQLPenaltyStore { db: db, table: table }
+}
+
+func (s *SQLPenaltyStore) Get (x context.Context, key string) (Penalty, bool, error) {
+  var (
+    deadline int64
+    penalty  Penalty
+  )
+  q := fmt.Sprintf("SELECT deadline, count FROM %s WHERE id = ?", s.table)
+  err := s.db.QueryRowContext(x, q, key).Scan(&deadline, &penalty.Count)
+  if sql.ErrNoRows == err {
+    return Penalty{}, false, nil
+  } else if nil != err {
+    return Penalty{}, false, err
+  }
+  penalty.Deadline = time.UnixMilli(deadline).UTC()
+  return penalty, true, nil
+}
+
+// Fail counts the failure with a single atomic increment. The deadline then
+// follows from the count, and only ever moves later
+func (s *SQLPenaltyStore) Fail (x context.Context, key string, c *Config) error {
+  var count int
+
+  t, err := s.db.BeginTx(x, nil)
+  if nil != err {
+    return err
+  }
+  defer t.Rollback() // Has no effect if transaction succeeds
+
+  q := fmt.Sprintf("INSERT INTO %s (id, deadline, count) VALUES (?,0,1) " +
+    "ON DUPLICATE KEY UPDATE count = count + 1", s.table)
+  if _, err = t.ExecContext(x, q, key); nil != err {
+    return err
+  }
+  q = fmt.Sprintf("SELECT count FROM %s WHERE id = ?", s.table)
+  if err = t.QueryRowContext(x, q, key).Scan(&count); nil != err {
+    return err
+  }
+  deadline := time.Now().UTC().Add(PenaltyDuration(c, count))
+  q = fmt.Sprintf("UPDATE %s SET deadline = GREATEST(deadline, ?) " +
+    "WHERE id = ?", s.table)
+  if _, err = t.ExecContext(x, q, deadline.UnixMilli(), key); nil != err {
+    return err
+  }
+  return t.Commit()
+}
+
+func (s *SQLPenaltyStore) Delete (x context.Context, key string) error {
+  q := fmt.Sprintf("DELETE FROM %s WHERE id = ?", s.table)
+  _, err := s.db.ExecContext(x, q, key)
+  return err
+}
+
+func (s *SQLPenaltyStore) List (x context.Context) (map[string]Penalty, error) {
+  var penalties map[string]Penalty = map[string]Penalty{}
+  q := fmt.Sprintf("SELECT id, deadline, count FROM %s", s.table)
+  rows, err := s.db.QueryContext(x, q)
+  if nil != err {
+    return nil, err
+  }
+  defer rows.Close()
+  for rows.Next() {
+    var (
+      key      string
+      deadline int64
+      penalty  Penalty
+    )
+    if err = rows.Scan(&key, &deadline, &penalty.Count); nil != err {
+      return nil, err
+    }
+    penalty.Deadline = time.UnixMilli(deadline).UTC()
+    penalties[key] = penalty
+  }
+  return penalties, rows.Err()
+}
+
+
+/*\
+ *******************************************************************************
+ *                        Definition: Penalty functions                        *
+ *******************************************************************************
+\*/
+
+
+// penalised returns true if the key has a penalty that has not yet lapsed.
+// If the store fails, the key is considered penalised
+func (s *Service) penalised (x context.Context, store PenaltyStore,
+  key string) bool {
+  return s.remaining(x, store, key) > 0
+}
+
+// remaining returns the time until the penalty of the key lapses. If the
+// store fails, the base penalty is assumed
+func (s *Service) remaining (x context.Context, store PenaltyStore,
+  key string) time.Duration {
+  penalty, ok, err := store.Get(x, key)
+  if nil != err {
+    log.Printf("Penalty store: %v\n", err)
+    return time.Duration(s.config.Base) * time.Second
+  }
+  if !ok {
+    return 0
+  }
+  return time.Until(penalty.Deadline)
+}
+
+// penalise counts a failure for the key, installing or extending its penalty.
+// The failure counts even if the request was cancelled
+func (s *Service) penalise (x context.Context, store PenaltyStore, key string) {
+  x, cancel := context.WithTimeout(context.WithoutCancel(x), StoreTimeout)
+  defer cancel()
+  if err := store.Fail(x, key, &s.config); nil != err {
+    log.Printf("Penalty store: %v\n", err)
+  }
+}
+
+// pardon removes any penalty of the key
+func (s *Service) pardon (x context.Context, store PenaltyStore, key string) {
+  if err := store.Delete(x, key); nil != err {
+    log.Printf("Penalty store: %v\n", err)
+  }
+}
@@ -0,0 +1,234 @@
+package auth
+
+import (
+  "context"
+  "database/sql"
+  "database/sql/driver"
+  "github.com/DATA-DOG/go-sqlmock"
+  "regexp"
+  "sync"
+  "testing"
+  "time"
+)
+
+const (
+  TestIP = "192.0.2.1"
+)
+
+
+/*\
+ *******************************************************************************
+ *                                   Helpers                                   *
+ *******************************************************************************
+\*/
+
+
+// deadlineAfter matches deadlines (in Unix milliseconds) from the time until
+// a second after it
+type deadlineAfter time.Time
+
+func (d deadlineAfter) Match (v driver.Value) bool {
+  ms, ok := v.(int64)
+  from := time.Time(d).UnixMilli()
+  return ok && ms >= from && ms <= from + 1000
+}
+
+// failAccount counts n failures of the account
+func failAccount (s *Service, username string, n int) {
+  for i := 0; i < n; i++ {
+    s.PenaliseAccount(context.Background(), username)
+  }
+}
+
+
+/*\
+ *******************************************************************************
+ *                                    Tests                                    *
+ *******************************************************************************
+\*/
+
+
+// TestAccountLockout verifies that accounts are locked out once their retries
+// are spent, independently of IPs and other accounts, until pardoned
+func TestAccountLockout (t *testing.T) {
+  x, s := context.Background(), newTestService(t)
+  failAccount(s, TestUsername, s.config.Retry)
+  if s.AccountPenalised(x, TestUsername) {
+    t.Fatalf("Account locked out within its retries")
+  }
+  failAccount(s, TestUsername, 1)
+  if !s.AccountPenalised(x, TestUsername) {
+    t.Fatalf("Account not locked out beyond its retries")
+  }
+  if s.AccountPenalised(x, "other") || s.Penalised(x, TestIP) {
+    t.Fatalf("Lockout applied beyond the account")
+  }
+  s.NoAccountPenalty(x, TestUsername)
+  if s.AccountPenalised(x, TestUsername) {
+    t.Fatalf("Account locked out once pardoned")
+  }
+}
+
+// TestRetryAfter verifies that the wait is that of the longer of the IP
+// penalty and account lockout, and zero if neither applies
+func TestRetryAfter (t *testing.T) {
+  x, s := context.Background(), newTestService(t)
+  base := time.Duration(s.config.Base) * time.Second
+  if wait := s.RetryAfter(x, TestIP, TestUsername); 0 != wait {
+    t.Fatalf("Wait %v without penalties", wait)
+  }
+
+  // The lockout alone (the base penalty)
+  failAccount(s, TestUsername, s.config.Retry + 1)
+  if wait := s.RetryAfter(x, TestIP, TestUsername); wait <= 0 || wait > base {
+    t.Fatalf("Wait %v with a lockout, expected at most %v", wait, base)
+  }
+
+  // The longer IP penalty (twice the base)
+  for i := 0; i < s.config.Retry + 2; i++ {
+    s.Penalise(x, TestIP)
+  }
+  if wait := s.RetryAfter(x, TestIP, TestUsername); wait <= base {
+    t.Fatalf("Wait %v with an IP penalty, expected over %v", wait, base)
+  }
+  if wait := s.RetryAfter(x, "192.0.2.2", "other"); 0 != wait {
+    t.Fatalf("Wait %v for another IP and account", wait)
+  }
+}
+
+// TestMemoryPenaltyStore verifies that concurrent failures all count, that
+// deadlines only move later, and that penalties are listed and deleted
+func TestMemoryPenaltyStore (t *testing.T) {
+  var (
+    x     context.Context     = context.Background()
+    m     *MemoryPenaltyStore = NewMemoryPenaltyStore()
+    c     Config              = Config{ Base: 1, Factor: 2, Limit: 8, Retry: 0 }
+    group sync.WaitGroup      = sync.WaitGroup{}
+  )
+  for i := 0; i < 64; i++ {
+    group.Add(1)
+    go func () {
+      defer group.Done()
+      m.Fail(x, TestIP, &c)
+    }()
+  }
+  group.Wait()
+  penalty, ok, err := m.Get(x, TestIP)
+  if nil != err || !ok || 64 != penalty.Count {
+    t.Fatalf("Penalty %+v (%v, %v), expected a count of 64", penalty, ok, err)
+  }
+  if wait := time.Until(penalty.Deadline); wait <= 0 ||
+    wait > time.Duration(c.Limit) * time.Second {
+    t.Fatalf("Penalty lasts %v, expected at most the limit", wait)
+  }
+
+  // A later deadline is kept
+  later := time.Now().UTC().Add(time.Hour)
+  m.penalties.Put(TestIP, Penalty{ Deadline: later, Count: 1 })
+  m.Fail(x, TestIP, &c)
+  if penalty, _, _ = m.Get(x, TestIP); !later.Equal(penalty.Deadline) ||
+    2 != penalty.Count {
+    t.Fatalf("Penalty %+v, expected the later deadline kept", penalty)
+  }
+
+  m.Fail(x, "192.0.2.2", &c)
+  if penalties, _ := m.List(x); 2 != len(penalties) {
+    t.Fatalf("Listed %d penalties, expected 2", len(penalties))
+  }
+  m.Delete(x, TestIP)
+  if _, ok, _ = m.Get(x, TestIP); ok {
+    t.Fatalf("Deleted penalty kept")
+  }
+}
+
+// TestSQLPenaltyStoreFail verifies that a failure is counted by a single
+// increment, and that the deadline follows from the count, in one transaction
+func TestSQLPenaltyStoreFail (t *testing.T) {
+  var (
+    x context.Context = context.Background()
+    c Config          = Config{ Base: 1, Factor: 2, Limit: 8, Retry: 0 }
+  )
+  db, mock, err := sqlmock.New()
+  if nil != err {
+    t.Fatalf("sqlmock failed: %v", err)
+  }
+  defer db.Close()
+  store := NewSQLPenaltyStore(db, "penalties")
+  now := time.Now().UTC()
+
+  // Third failure: a penalty of four times the base
+  mock.ExpectBegin()
+  mock.ExpectExec(regexp.QuoteMeta("INSERT INTO penalties (id, deadline, " +
+    "count) VALUES (?,0,1) ON DUPLICATE KEY UPDATE count = count + 1")).
+    WithArgs(TestIP).WillReturnResult(sqlmock.NewResult(0, 2))
+  mock.ExpectQuery(regexp.QuoteMeta("SELECT count FROM penalties WHERE id = ?")).
+    WithArgs(TestIP).WillReturnRows(sqlmock.NewRows([]string{ "count" }).
+    AddRow(3))
+  mock.ExpectExec(regexp.QuoteMeta("UPDATE penalties SET deadline = " +
+    "GREATEST(deadline, ?) WHERE id = ?")).
+    WithArgs(deadlineAfter(now.Add(4 * time.Second)), TestIP).
+    WillReturnResult(sqlmock.NewResult(0, 1))
+  mock.ExpectCommit()
+  if err = store.Fail(x, TestIP, &c); nil != err {
+    t.Fatalf("Fail failed: %v", err)
+  }
+
+  // Nothing is kept if counting fails
+  mock.ExpectBegin()
+  mock.ExpectExec("INSERT INTO penalties").WithArgs(TestIP).
+    WillReturnError(sql.ErrConnDone)
+  mock.ExpectRollback()
+  if err = store.Fail(x, TestIP, &c); nil == err {
+    t.Fatalf("Fail succeeded without counting")
+  }
+  if err = mock.ExpectationsWereMet(); nil != err {
+    t.Fatalf("Unmet expectations: %v", err)
+  }
+}
+
+// TestSQLPenaltyStore verifies that penalties are read (with deadlines in
+// Unix milliseconds), listed and deleted
+func TestSQLPenaltyStore (t *testing.T) {
+  x := context.Background()
+  db, mock, err := sqlmock.New()
+  if nil != err {
+    t.Fatalf("sqlmock failed: %v", err)
+  }
+  defer db.Close()
+  store := NewSQLPenaltyStore(db, "penalties")
+  deadline := time.UnixMilli(time.Now().Add(time.Minute).UnixMilli()).UTC()
+
+  mock.ExpectQuery(regexp.QuoteMeta("SELECT deadline, count FROM penalties " +
+    "WHERE id = ?")).WithArgs(TestIP).
+    WillReturnRows(sqlmock.NewRows([]string{ "deadline", "count" }).
+    AddRow(deadline.UnixMilli(), 2))
+  penalty, ok, err := store.Get(x, TestIP)
+  if nil != err || !ok || 2 != penalty.Count ||
+    !deadline.Equal(penalty.Deadline) {
+    t.Fatalf("Get gave %+v (%v, %v)", penalty, ok, err)
+  }
+
+  mock.ExpectQuery("SELECT deadline, count FROM penalties").WithArgs("other").
+    WillReturnRows(sqlmock.NewRows([]string{ "deadline", "count" }))
+  if _, ok, err = store.Get(x, "other"); nil != err || ok {
+    t.Fatalf("Get found a missing penalty (%v)", err)
+  }
+
+  mock.ExpectQuery(regexp.QuoteMeta("SELECT id, deadline, count FROM " +
+    "penalties")).WillReturnRows(sqlmock.NewRows([]string{ "id", "deadline",
+    "count" }).AddRow(TestIP, deadline.UnixMilli(), 2).
+    AddRow(TestUsername, deadline.UnixMilli(), 5))
+  penalties, err := store.List(x)
+  if nil != err || 2 != len(penalties) || 5 != penalties[TestUsername].Count {
+    t.Fatalf("List gave %+v (%v)", penalties, err)
+  }
+
+  mock.ExpectExec(regexp.QuoteMeta("DELETE FROM penalties WHERE id = ?")).
+    WithArgs(TestIP).WillReturnResult(sqlmock.NewResult(0, 1))
+  if err = store.Delete(x, TestIP); nil != err {
+    t.Fatalf("Delete failed: %v", err)
+  }
+  if err = mock.ExpectationsWereMet(); nil != err {
+    t.Fatalf("Unmet expectations: %v", err)
+  }
+}
@@ -0,0 +1,39 @@
+package auth
+
+import (
+  "crypto/rand"
+  "encoding/hex"
+  "golang.org/x/crypto/sha3"
+  "time"
+)
+
+const (
+  ResetTokenSize = 32
+  ResetPeriod    = 30 * time.Minute
+  ResetInterval  = 1 * time.Minute
+)
+
+
+/*\
+ *******************************************************************************
+ *                          Definition: Password reset                         *
+ *******************************************************************************
+\*/
+
+
+// NewResetToken returns a new password reset token, and the digest under
+// which it is stored. Tokens are never stored in the clear
+func NewResetToken () (string, []byte, error) {
+  b := make([]byte, ResetTokenSize)
+  if _, err := rand.Read(b); nil != err {
+    return "", nil, err
+  }
+  token := hex.EncodeToString(b)
+  return token, ResetDigest(token), nil
+}
+
+// ResetDigest returns the digest under which the reset token is stored
+func ResetDigest (token string) []byte {
+  digest := sha3.Sum256([]byte(token))
+  return digest[:]
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+  "slices"
+)
+
+const (
+  RoleAdmin     = "admin"
+  RoleEditor    = "editor"
+  RoleAuthor    = "author"
+  RoleModerator = "commenter-moderator"
+)
+
+const (
+  PermissionBlogWrite        = "blog:write"
+  PermissionBlogWriteOwn     = "blog:write:own"
+  PermissionCommentsModerate = "comments:moderate"
+  PermissionUsersManage      = "users:manage"
+  PermissionAuditRead        = "audit:read"
+)
+
+
+/*\
+ *******************************************************************************
+ *                              Definition: Roles                              *
+ *******************************************************************************
+\*/
+
+
+// roles: The permissions granted by each role. Writing any post implies
+// writing one's own post
+var roles = map[string][]string {
+  RoleAdmin: {
+    PermissionBlogWrite,
+    PermissionBlogWriteOwn,
+    PermissionCommentsModerate,
+    PermissionUsersManage,
+    PermissionAuditRead,
+  },
+  RoleEditor: {
+    PermissionBlogWrite,
+    PermissionBlogWriteOwn,
+  },
+  RoleAuthor: {
+    PermissionBlogWriteOwn,
+  },
+  RoleModerator: {
+    PermissionCommentsModerate,
+  },
+}
+
+// ValidRole returns true if the role exists
+func ValidRole (role string) bool {
+  _, ok := roles[role]
+  return ok
+}
+
+// Permissions returns the permissions granted by the role
+func Permissions (role string) []string {
+  return slices.Clone(roles[role])
+}
+
+// Permitted returns true if any of the required permissions is granted, or
+// if no permissions are required
+func Permitted (granted, required []string) bool {
+  if 0 == len(required) {
+    return true
+  }
+  for _, p := range required {
+    if slices.Contains(granted, p) {
+      return true
+    }
+  }
+  return false
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+  "testing"
+  "time"
+)
+
+
+/*\
+ *******************************************************************************
+ *                                    Tests                                    *
+ *******************************************************************************
+\*/
+
+
+// TestNewSession verifies that new sessions expire once idle, or at their
+// deadline if that is sooner
+func TestNewSession (t *testing.T) {
+  c := Client{ IP: TestIP, Agent: "browser" }
+  for _, d := range []struct {
+    Period, Lifetime, Expected time.Duration
+  } {
+    { time.Minute, time.Hour, time.Minute },
+    { time.Hour, time.Minute, time.Minute },
+  } {
+    before := time.Now().UTC()
+    z, err := NewSession(c, d.Period, d.Lifetime)
+    if nil != err {
+      t.Fatalf("NewSession failed: %v", err)
+    }
+    if z.Expiration.Before(before.Add(d.Expected)) ||
+      z.Expiration.After(time.Now().UTC().Add(d.Expected)) {
+      t.Fatalf("Session of %v (for %v) expires at %v, expected in %v",
+        d.Period, d.Lifetime, z.Expiration, d.Expected)
+    }
+    if z.Deadline.Before(before.Add(d.Lifetime)) {
+      t.Fatalf("Session deadline %v, expected in %v", z.Deadline, d.Lifetime)
+    }
+  }
+}
+
+// TestSessionRenew verifies that renewing a session extends it by its
+// period, but never beyond its deadline
+func TestSessionRenew (t *testing.T) {
+  now := time.Now().UTC()
+  z := Session {
+    Expiration: now.Add(time.Second),
+    Deadline:   now.Add(time.Hour),
+    Period:     time.Minute,
+  }
+  if renewed := z.Renew(); renewed.Expiration.Before(now.Add(time.Minute)) ||
+    !z.Deadline.Equal(renewed.Deadline) {
+    t.Fatalf("Renewed to %v (deadline %v)", renewed.Expiration,
+      renewed.Deadline)
+  }
+  z.Deadline = now.Add(10 * time.Second)
+  if renewed := z.Renew(); !z.Deadline.Equal(renewed.Expiration) {
+    t.Fatalf("Renewed to %v, beyond the deadline %v", renewed.Expiration,
+      z.Deadline)
+  }
+}
+
+// TestSessionDeadline verifies that sessions in use are renewed up to their
+// deadline, and refused after it however active
+func TestSessionDeadline (t *testing.T) {
+  s := newBindingService(t, BindNone, false)
+  token := authenticate(t, s, Client{})
+  z, _ := s.sessions.Get(TestUsername)
+  z.Deadline = time.Now().UTC().Add(time.Second)
+  s.sessions.Put(TestUsername, z)
+  if err := s.Authorized(Client{}, TestUsername, token); nil != err {
+    t.Fatalf("Session refused before its deadline: %v", err)
+  }
+  if z, _ = s.sessions.Get(TestUsername); z.Expiration.After(z.Deadline) {
+    t.Fatalf("Session renewed to %v, beyond its deadline %v", z.Expiration,
+      z.Deadline)
+  }
+
+  // Past the deadline
+  z.Expiration, z.Deadline = z.Deadline.Add(-time.Hour),
+    z.Deadline.Add(-time.Hour)
+  s.sessions.Put(TestUsername, z)
+  if err := s.Authorized(Client{}, TestUsername, token); nil == err {
+    t.Fatalf("Session accepted after its deadline")
+  }
+}
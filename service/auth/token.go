@@ -0,0 +1,331 @@
+package auth
+
+import (
+  "context"
+  "crypto/hmac"
+  "crypto/rand"
+  "crypto/sha256"
+  "encoding/base64"
+  "encoding/hex"
+  "encoding/json"
+  "fmt"
+  "golang.org/x/crypto/sha3"
+  "strings"
+  "time"
+)
+
+const (
+  TokenAlgorithm = "HS256"
+  TokenType      = "JWT"
+  MinKeySize     = 32
+)
+
+
+/*\
+ *******************************************************************************
+ *                              Definition: Keys                               *
+ *******************************************************************************
+\*/
+
+
+// Key is a named HMAC-SHA256 signing key. The secret is hex encoded
+type Key struct {
+  ID     string
+  Secret string
+}
+
+// TokenConfig enables stateless access tokens when at least one key is given.
+// Tokens are signed with the first key, and verified against any key in the
+// set. Keys may therefore be rotated by prepending a new key, and dropping
+// the oldest key once all tokens signed with it have expired
+type TokenConfig struct {
+  Keys    []Key
+  Access  int
+  Refresh int
+}
+
+// keySet: Decoded signing keys, indexed by identifier
+type keySet struct {
+  active string
+  keys   map[string][]byte
+}
+
+// newKeySet: Decodes and validates the configured keys
+func newKeySet (c *TokenConfig) (keySet, error) {
+  set := keySet {
+    keys: make(map[string][]byte),
+  }
+  for i, key := range c.Keys {
+    b, err := hex.DecodeString(key.Secret)
+    if nil != err {
+      return set, fmt.Errorf("Key %q is not hex encoded: %w", key.ID, err)
+    }
+    if len(b) < MinKeySize {
+      return set, fmt.Errorf("Unmet condition: Key %q size >= %d bytes", key.ID,
+        MinKeySize)
+    }
+    if _, ok := set.keys[key.ID]; ok {
+      return set, fmt.Errorf("Duplicate key %q", key.ID)
+    }
+    if 0 == i {
+      set.active = key.ID
+    }
+    set.keys[key.ID] = b
+  }
+  return set, nil
+}
+
+
+/*\
+ *******************************************************************************
+ *                             Definition: Tokens                              *
+ *******************************************************************************
+\*/
+
+
+type tokenHeader struct {
+  Algorithm string `json:"alg"`
+  Type      string `json:"typ"`
+  KeyID     string `json:"kid"`
+}
+
+// Claims carried by a signed access token
+type Claims struct {
+  Subject    string   `json:"sub"`
+  IssuedAt   int64    `json:"iat"`
+  Expiration int64    `json:"exp"`
+  Scopes     []string `json:"scopes"`
+}
+
+// Expired: Returns true if the claims are no longer valid
+func (c *Claims) Expired () bool {
+  return time.Now().UTC().Unix() >= c.Expiration
+}
+
+// Refresh: A server-side record of an issued refresh token
+type Refresh struct {
+  Username   string
+  Expiration time.Time
+}
+
+// Grant: The pair of tokens issued on login or refresh
+type Grant struct {
+  Access     string
+  Refresh    string
+  Expiration time.Time
+  Renewal    time.Time
+}
+
+// sign: Returns the signature of the message under the given key
+func sign (key, message []byte) []byte {
+  mac := hmac.New(sha256.New, key)
+  mac.Write(message)
+  return mac.Sum(nil)
+}
+
+// refreshKey: Refresh tokens are stored by digest, never in the clear
+func refreshKey (token string) string {
+  digest := sha3.Sum256([]byte(token))
+  return hex.EncodeToString(digest[:])
+}
+
+// Signed returns true if the token has the form of a signed access token
+func Signed (token string) bool {
+  return 2 == strings.Count(token, ".")
+}
+
+// encodeToken: Returns the compact serialization of the signed claims
+func (k *keySet) encodeToken (claims Claims) (string, error) {
+  encoding := base64.RawURLEncoding
+  header, err := json.Marshal(tokenHeader {
+    Algorithm: TokenAlgorithm,
+    Type:      TokenType,
+    KeyID:     k.active,
+  })
+  if nil != err {
+    return "", err
+  }
+  payload, err := json.Marshal(claims)
+  if nil != err {
+    return "", err
+  }
+  message := encoding.EncodeToString(header) + "." +
+    encoding.EncodeToString(payload)
+  signature := sign(k.keys[k.active], []byte(message))
+  return message + "." + encoding.EncodeToString(signature), nil
+}
+
+// decodeToken: Verifies the token signature and returns the enclosed claims
+func (k *keySet) decodeToken (token string) (Claims, error) {
+  var (
+    encoding  = base64.RawURLEncoding
+    claims    Claims      = Claims{}
+    header    tokenHeader = tokenHeader{}
+  )
+
+  parts := strings.Split(token, ".")
+  if 3 != len(parts) {
+    return claims, fmt.Errorf("Malformed token")
+  }
+
+  // Decode header; select key
+  b, err := encoding.DecodeString(parts[0])
+  if nil != err {
+    return claims, fmt.Errorf("Malformed token header: %w", err)
+  }
+  if err = json.Unmarshal(b, &header); nil != err {
+    return claims, fmt.Errorf("Malformed token header: %w", err)
+  }
+  if TokenAlgorithm != header.Algorithm {
+    return claims, fmt.Errorf("Unsupported token algorithm %q", header.Algorithm)
+  }
+  key, ok := k.keys[header.KeyID]
+  if !ok {
+    return claims, fmt.Errorf("Unknown token key %q", header.KeyID)
+  }
+
+  // Verify signature (constant time)
+  signature, err := encoding.DecodeString(parts[2])
+  if nil != err {
+    return claims, fmt.Errorf("Malformed token signature: %w", err)
+  }
+  if !hmac.Equal(signature, sign(key, []byte(parts[0] + "." + parts[1]))) {
+    return claims, fmt.Errorf("Token signature mismatch")
+  }
+
+  // Decode claims
+  if b, err = encoding.DecodeString(parts[1]); nil != err {
+    return claims, fmt.Errorf("Malformed token claims: %w", err)
+  }
+  if err = json.Unmarshal(b, &claims); nil != err {
+    return claims, fmt.Errorf("Malformed token claims: %w", err)
+  }
+  if claims.Expired() {
+    return claims, fmt.Errorf("Token expired")
+  }
+  return claims, nil
+}
+
+
+/*\
+ *******************************************************************************
+ *                          Definition: Service Tokens                         *
+ *******************************************************************************
+\*/
+
+
+// Stateless returns true if logins are granted signed access tokens rather
+// than sessions
+func (s *Service) Stateless () bool {
+  return len(s.keys.keys) > 0
+}
+
+// issue: Creates a new grant for the user, registering the refresh token
+func (s *Service) issue (x context.Context, username string,
+  scopes []string) (Grant, error) {
+  var (
+    b   []byte    = make([]byte, HashSize)
+    now time.Time = time.Now().UTC()
+    z   Grant     = Grant{}
+    err error     = nil
+  )
+
+  // Sign access token
+  z.Expiration = now.Add(time.Duration(s.config.Tokens.Access) * time.Second)
+  z.Access, err = s.keys.encodeToken(Claims {
+    Subject:    username,
+    IssuedAt:   now.Unix(),
+    Expiration: z.Expiration.Unix(),
+    Scopes:     scopes,
+  })
+  if nil != err {
+    return Grant{}, err
+  }
+
+  // Generate refresh token
+  if _, err = rand.Read(b); nil != err {
+    return Grant{}, err
+  }
+  z.Refresh = hex.EncodeToString(b)
+  z.Renewal = now.Add(time.Duration(s.config.Tokens.Refresh) * time.Second)
+  err = s.grants.Put(x, refreshKey(z.Refresh), Refresh {
+    Username:   username,
+    Expiration: z.Renewal,
+  })
+  if nil != err {
+    return Grant{}, err
+  }
+
+  return z, nil
+}
+
+// Grant executes the given authentication function in a thread safe context.
+// If the authentication function returns (true, nil), then a new token grant
+// with the given scopes is returned. Otherwise, an empty grant is returned and
+// the returned values of the authentication function propagated back
+func (s *Service) Grant (x context.Context, username string, scopes []string,
+  f AuthFunc) (Grant, bool, error) {
+  var (
+    ok  bool  = false
+    err error = nil
+  )
+
+  // Secure mutual exclusion for duration of authentication
+  s.mutex.Lock()
+  defer s.mutex.Unlock()
+
+  // Case: error during auth or bad credentials
+  if ok, err = f(); nil != err || !ok {
+    return Grant{}, ok, err
+  }
+
+  z, err := s.issue(x, username, scopes)
+  return z, ok, err
+}
+
+// ScopeFunc: Returns the scopes currently granted to the username
+type ScopeFunc func (string) ([]string, error)
+
+// Renew exchanges a refresh token for a new grant, with the scopes currently
+// granted to the user. The refresh token is single use: it is revoked whether
+// or not renewal succeeds
+func (s *Service) Renew (x context.Context, token string,
+  f ScopeFunc) (Grant, error) {
+  r, ok, err := s.grants.Take(x, refreshKey(token))
+  if nil != err {
+    return Grant{}, err
+  } else if !ok {
+    return Grant{}, fmt.Errorf("Unknown refresh token")
+  }
+
+  if time.Now().UTC().After(r.Expiration) {
+    return Grant{}, fmt.Errorf("Refresh token expired")
+  }
+  scopes, err := f(r.Username)
+  if nil != err {
+    return Grant{}, err
+  }
+  return s.issue(x, r.Username, scopes)
+}
+
+// Verify returns the claims of a signed access token if the token is valid,
+// and was not issued before the subject's tokens were last revoked. Tokens
+// issued in the second of the revocation are refused too, as issue times
+// are only kept to the second
+func (s *Service) Verify (x context.Context, token string) (Claims, error) {
+  if !s.Stateless() {
+    return Claims{}, fmt.Errorf("Signed tokens are not enabled")
+  }
+  claims, err := s.keys.decodeToken(token)
+  if nil != err {
+    return claims, err
+  }
+  t, ok, err := s.grants.Revoked(x, claims.Subject)
+  if nil != err {
+    return claims, err
+  }
+  if ok && claims.IssuedAt <= t.Unix() {
+    return claims, fmt.Errorf("Token revoked")
+  }
+  return claims, nil
+}
@@ -0,0 +1,236 @@
+package auth
+
+import (
+  "context"
+  "encoding/base64"
+  "encoding/hex"
+  "strings"
+  "testing"
+  "time"
+)
+
+const (
+  TestUsername = "tester"
+)
+
+
+/*\
+ *******************************************************************************
+ *                                   Helpers                                   *
+ *******************************************************************************
+\*/
+
+
+// testKey returns a hex encoded key of the minimum size, filled with b
+func testKey (b byte) string {
+  return hex.EncodeToString([]byte(strings.Repeat(string(b), MinKeySize)))
+}
+
+func newTestService (t *testing.T) *Service {
+  s, err := NewService(Config {
+    Base: 1, Factor: 2, Limit: 8, Retry: 3,
+    Tokens: TokenConfig {
+      Keys:    []Key{ { ID: "a", Secret: testKey('a') } },
+      Access:  60,
+      Refresh: 60,
+    },
+  })
+  if nil != err {
+    t.Fatalf("NewService failed: %v", err)
+  }
+  return &s
+}
+
+// grant issues a grant to the test user, with no scopes
+func grant (t *testing.T, s *Service) Grant {
+  z, ok, err := s.Grant(context.Background(), TestUsername, nil,
+    func () (bool, error) { return true, nil })
+  if nil != err || !ok {
+    t.Fatalf("Grant failed: %v", err)
+  }
+  return z
+}
+
+// noScopes grants no scopes to any user
+func noScopes (string) ([]string, error) {
+  return nil, nil
+}
+
+// claimsOf returns the token with its claims replaced, keeping the header and
+// signature
+func claimsOf (t *testing.T, token string, claims Claims) string {
+  s, err := (&keySet {
+    active: "a",
+    keys:   map[string][]byte{ "a": []byte("forged") },
+  }).encodeToken(claims)
+  if nil != err {
+    t.Fatalf("encodeToken failed: %v", err)
+  }
+  parts, forged := strings.Split(token, "."), strings.Split(s, ".")
+  return parts[0] + "." + forged[1] + "." + parts[2]
+}
+
+
+/*\
+ *******************************************************************************
+ *                                    Tests                                    *
+ *******************************************************************************
+\*/
+
+
+// TestTokenVerify verifies that an issued access token resolves to its claims
+func TestTokenVerify (t *testing.T) {
+  s := newTestService(t)
+  z := grant(t, s)
+  claims, err := s.Verify(context.Background(), z.Access)
+  if nil != err {
+    t.Fatalf("Verify failed: %v", err)
+  }
+  if TestUsername != claims.Subject {
+    t.Fatalf("Subject %q, expected %q", claims.Subject, TestUsername)
+  }
+  if claims.Expiration != z.Expiration.Unix() {
+    t.Fatalf("Expiration %d, expected %d", claims.Expiration,
+      z.Expiration.Unix())
+  }
+}
+
+// TestTokenTamper verifies that tokens with altered claims, headers or
+// signatures, or signed with another key, are rejected
+func TestTokenTamper (t *testing.T) {
+  var (
+    x        context.Context = context.Background()
+    encoding                 = base64.RawURLEncoding
+  )
+  s := newTestService(t)
+  z := grant(t, s)
+  parts := strings.Split(z.Access, ".")
+  claims, err := s.Verify(x, z.Access)
+  if nil != err {
+    t.Fatalf("Verify failed: %v", err)
+  }
+
+  // Altered claims
+  claims.Subject = "admin"
+  if _, err = s.Verify(x, claimsOf(t, z.Access, claims)); nil == err {
+    t.Fatalf("Altered claims accepted")
+  }
+
+  // Altered signature
+  signature, _ := encoding.DecodeString(parts[2])
+  signature[0] ^= 1
+  forged := parts[0] + "." + parts[1] + "." + encoding.EncodeToString(signature)
+  if _, err = s.Verify(x, forged); nil == err {
+    t.Fatalf("Altered signature accepted")
+  }
+
+  // Unsigned, unknown key, or malformed
+  for _, header := range []string {
+    `{"alg":"none","typ":"JWT","kid":"a"}`,
+    `{"alg":"HS256","typ":"JWT","kid":"b"}`,
+    `{"alg":"HS256"`,
+  } {
+    forged = encoding.EncodeToString([]byte(header)) + "." + parts[1] + "." +
+      parts[2]
+    if _, err = s.Verify(x, forged); nil == err {
+      t.Fatalf("Header %s accepted", header)
+    }
+  }
+  for _, forged = range []string {
+    parts[0] + "." + parts[1],
+    parts[0] + "." + parts[1] + ".",
+    parts[0] + "." + parts[1] + "." + parts[2] + ".",
+  } {
+    if _, err = s.Verify(x, forged); nil == err {
+      t.Fatalf("Malformed token %q accepted", forged)
+    }
+  }
+
+  // Signed with another key of the same identifier
+  other := newTestService(t)
+  other.keys.keys["a"], _ = hex.DecodeString(testKey('b'))
+  if _, err = s.Verify(x, grant(t, other).Access); nil == err {
+    t.Fatalf("Token signed with another key accepted")
+  }
+}
+
+// TestTokenExpiry verifies that expired access tokens are rejected
+func TestTokenExpiry (t *testing.T) {
+  s := newTestService(t)
+  now := time.Now().UTC()
+  for _, expiration := range []time.Time { now, now.Add(-time.Hour) } {
+    token, err := s.keys.encodeToken(Claims {
+      Subject:    TestUsername,
+      IssuedAt:   expiration.Add(-time.Minute).Unix(),
+      Expiration: expiration.Unix(),
+    })
+    if nil != err {
+      t.Fatalf("encodeToken failed: %v", err)
+    }
+    if _, err = s.Verify(context.Background(), token); nil == err {
+      t.Fatalf("Token expiring at %v accepted", expiration)
+    }
+  }
+}
+
+// TestTokenRevocation verifies that access tokens issued before (or in the
+// second of) the subject's revocation are rejected, and that refresh tokens
+// are revoked with them
+func TestTokenRevocation (t *testing.T) {
+  x, s := context.Background(), newTestService(t)
+  now := time.Now().UTC()
+  token, err := s.keys.encodeToken(Claims {
+    Subject:    TestUsername,
+    IssuedAt:   now.Add(-time.Minute).Unix(),
+    Expiration: now.Add(time.Minute).Unix(),
+  })
+  if nil != err {
+    t.Fatalf("encodeToken failed: %v", err)
+  }
+  z := grant(t, s)
+  if err = s.Revoke(x, TestUsername); nil != err {
+    t.Fatalf("Revoke failed: %v", err)
+  }
+  if _, err = s.Verify(x, token); nil == err {
+    t.Fatalf("Revoked token accepted")
+  }
+  if _, err = s.Verify(x, z.Access); nil == err {
+    t.Fatalf("Token issued in the second of the revocation accepted")
+  }
+  if _, err = s.Renew(x, z.Refresh, noScopes); nil == err {
+    t.Fatalf("Revoked refresh token accepted")
+  }
+}
+
+// TestTokenRenew verifies that refresh tokens are single use, expire, and
+// are pruned once expired
+func TestTokenRenew (t *testing.T) {
+  x, s := context.Background(), newTestService(t)
+  z := grant(t, s)
+  renewed, err := s.Renew(x, z.Refresh, noScopes)
+  if nil != err {
+    t.Fatalf("Renew failed: %v", err)
+  }
+  if _, err = s.Renew(x, z.Refresh, noScopes); nil == err {
+    t.Fatalf("Refresh token accepted twice")
+  }
+
+  // Expired refresh tokens are refused, and pruned
+  s.grants.Put(x, refreshKey(renewed.Refresh), Refresh {
+    Username:   TestUsername,
+    Expiration: time.Now().UTC().Add(-time.Second),
+  })
+  if err = s.prune(); nil != err {
+    t.Fatalf("prune failed: %v", err)
+  }
+  if _, ok, _ := s.grants.Take(x, refreshKey(renewed.Refresh)); ok {
+    t.Fatalf("Expired refresh token not pruned")
+  }
+  s.grants.Put(x, refreshKey(renewed.Refresh), Refresh {
+    Username:   TestUsername,
+    Expiration: time.Now().UTC().Add(-time.Second),
+  })
+  if _, err = s.Renew(x, renewed.Refresh, noScopes); nil == err {
+    t.Fatalf("Expired refresh token accepted")
+  }
+}
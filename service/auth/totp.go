@@ -0,0 +1,205 @@
+package auth
+
+import (
+  "crypto/hmac"
+  "crypto/rand"
+  "crypto/sha1"
+  "crypto/subtle"
+  "encoding/base32"
+  "encoding/binary"
+  "encoding/hex"
+  "fmt"
+  "net/url"
+  "strings"
+  "time"
+)
+
+const (
+  TOTPSecretSize   = 20
+  TOTPDigits       = 6
+  TOTPStep         = 30
+  TOTPSkew         = 1
+  RecoveryCodes    = 10
+  RecoveryCodeSize = 5
+  ChallengePeriod  = 5 * time.Minute
+)
+
+
+/*\
+ *******************************************************************************
+ *                   Definitions: Time-based one-time passwords                *
+ *******************************************************************************
+\*/
+
+
+// totpEncoding: Unpadded base32 as expected by authenticator applications
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTOTPSecret: Returns a new random base32 encoded TOTP secret
+func NewTOTPSecret () (string, error) {
+  b := make([]byte, TOTPSecretSize)
+  if _, err := rand.Read(b); nil != err {
+    return "", err
+  }
+  return totpEncoding.EncodeToString(b), nil
+}
+
+// ProvisioningURI: Returns the otpauth URI used to enroll the secret with an
+// authenticator application (typically rendered as a QR code)
+func ProvisioningURI (issuer, account, secret string) string {
+  v := url.Values{}
+  v.Set("secret", secret)
+  v.Set("issuer", issuer)
+  v.Set("algorithm", "SHA1")
+  v.Set("digits", fmt.Sprint(TOTPDigits))
+  v.Set("period", fmt.Sprint(TOTPStep))
+  return (&url.URL {
+    Scheme:   "otpauth",
+    Host:     "totp",
+    Path:     "/" + issuer + ":" + account,
+    RawQuery: v.Encode(),
+  }).String()
+}
+
+// hotp: Returns the RFC 4226 one-time password for the given counter
+func hotp (key []byte, counter uint64) string {
+  var b [8]byte
+  binary.BigEndian.PutUint64(b[:], counter)
+  mac := hmac.New(sha1.New, key)
+  mac.Write(b[:])
+  sum := mac.Sum(nil)
+
+  // Dynamic truncation
+  offset := sum[len(sum) - 1] & 0x0f
+  value := binary.BigEndian.Uint32(sum[offset:offset + 4]) & 0x7fffffff
+  modulus := uint32(1)
+  for i := 0; i < TOTPDigits; i++ {
+    modulus *= 10
+  }
+  return fmt.Sprintf("%0*d", TOTPDigits, value % modulus)
+}
+
+// TOTPStepAt: Returns the RFC 6238 time step containing t
+func TOTPStepAt (t time.Time) int64 {
+  return t.Unix() / TOTPStep
+}
+
+// TOTP: Returns the one-time password for the secret at time step n
+func TOTP (secret string, n int64) (string, error) {
+  key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+  if nil != err {
+    return "", fmt.Errorf("Malformed TOTP secret: %w", err)
+  }
+  return hotp(key, uint64(n)), nil
+}
+
+// VerifyTOTP checks the code against the secret for the time steps
+// surrounding t (allowing for clock skew). Only steps after the last
+// accepted step are considered, so that a code cannot be replayed. The
+// matching step is returned if successful
+func VerifyTOTP (secret, code string, t time.Time, last int64) (int64, bool) {
+  now := TOTPStepAt(t)
+  for n := now - TOTPSkew; n <= now + TOTPSkew; n++ {
+    if n <= last {
+      continue
+    }
+    expected, err := TOTP(secret, n)
+    if nil != err {
+      return 0, false
+    }
+    if 1 == subtle.ConstantTimeCompare([]byte(expected), []byte(code)) {
+      return n, true
+    }
+  }
+  return 0, false
+}
+
+// NewRecoveryCodes: Returns a set of random single-use recovery codes. The
+// codes are shown once to the user and must be stored hashed (see NewSecret)
+func NewRecoveryCodes () ([]string, error) {
+  codes := make([]string, RecoveryCodes)
+  for i := range codes {
+    b := make([]byte, RecoveryCodeSize)
+    if _, err := rand.Read(b); nil != err {
+      return nil, err
+    }
+    h := hex.EncodeToString(b)
+    codes[i] = h[:RecoveryCodeSize] + "-" + h[RecoveryCodeSize:]
+  }
+  return codes, nil
+}
+
+
+/*\
+ *******************************************************************************
+ *                            Definition: Challenge                            *
+ *******************************************************************************
+\*/
+
+
+// Challenge: A login that has passed the first factor and awaits a code
+type Challenge struct {
+  Username   string
+  IP         string
+  Period     string
+  Expiration time.Time
+}
+
+// Expired: Returns true if the challenge may no longer be answered
+func (c *Challenge) Expired () bool {
+  return time.Now().UTC().After(c.Expiration)
+}
+
+// Challenge executes the given authentication function in a thread safe
+// context, in the manner of Authenticate. On success, a pending second factor
+// challenge is registered instead of a session, and its token returned
+func (s *Service) Challenge (ip, username, period string, f AuthFunc) (string, Challenge, bool, error) {
+  var (
+    b   []byte = make([]byte, HashSize)
+    ok  bool   = false
+    err error  = nil
+  )
+
+  // Secure mutual exclusion for duration of authentication
+  s.mutex.Lock()
+  defer s.mutex.Unlock()
+
+  // Case: error during auth or bad credentials
+  if ok, err = f(); nil != err || !ok {
+    return "", Challenge{}, ok, err
+  }
+
+  if _, err = rand.Read(b); nil != err {
+    return "", Challenge{}, ok, err
+  }
+  token, z := hex.EncodeToString(b), Challenge {
+    Username:   username,
+    IP:         ip,
+    Period:     period,
+    Expiration: time.Now().UTC().Add(ChallengePeriod),
+  }
+  s.challenges.Put(token, z)
+  return token, z, ok, nil
+}
+
+// Pending returns the challenge for the given token if it exists, has not
+// expired, and was issued to the same IP
+func (s *Service) Pending (ip, token string) (Challenge, error) {
+  z, ok := s.challenges.Get(token)
+  if !ok {
+    return Challenge{}, fmt.Errorf("No such challenge")
+  }
+  if z.Expired() {
+    s.challenges.Delete(token)
+    return Challenge{}, fmt.Errorf("Challenge expired")
+  }
+  if ip != z.IP {
+    return Challenge{}, fmt.Errorf("Challenge IP mismatch")
+  }
+  return z, nil
+}
+
+// Answered removes the challenge once the second factor has been verified
+func (s *Service) Answered (token string) {
+  s.challenges.Delete(token)
+}
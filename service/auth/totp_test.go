@@ -0,0 +1,83 @@
+package auth
+
+import (
+  "testing"
+  "time"
+)
+
+// TestTOTPSecret is the RFC 6238 SHA1 test key ("12345678901234567890")
+const TestTOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// totpVectors: RFC 6238 (Appendix B) SHA1 vectors, truncated to TOTPDigits
+var totpVectors = []struct {
+  Time int64
+  Code string
+} {
+  { 59,          "287082" },
+  { 1111111109,  "081804" },
+  { 1111111111,  "050471" },
+  { 1234567890,  "005924" },
+  { 2000000000,  "279037" },
+  { 20000000000, "353130" },
+}
+
+
+/*\
+ *******************************************************************************
+ *                                    Tests                                    *
+ *******************************************************************************
+\*/
+
+
+// TestTOTP verifies the codes generated against the RFC 6238 vectors
+func TestTOTP (t *testing.T) {
+  for _, v := range totpVectors {
+    code, err := TOTP(TestTOTPSecret, TOTPStepAt(time.Unix(v.Time, 0)))
+    if nil != err {
+      t.Fatalf("TOTP failed: %v", err)
+    }
+    if v.Code != code {
+      t.Fatalf("Code at %d is %s, expected %s", v.Time, code, v.Code)
+    }
+  }
+  if _, err := TOTP("not base32!", 1); nil == err {
+    t.Fatalf("Malformed secret accepted")
+  }
+}
+
+// TestVerifyTOTP verifies that codes are accepted within the permitted skew,
+// and that codes at or before the last accepted step are refused
+func TestVerifyTOTP (t *testing.T) {
+  for _, v := range totpVectors {
+    at := time.Unix(v.Time, 0)
+    step := TOTPStepAt(at)
+
+    // Within the skew, either side
+    for _, skew := range []int64 { -TOTPSkew, 0, TOTPSkew } {
+      when := at.Add(time.Duration(skew * TOTPStep) * time.Second)
+      n, ok := VerifyTOTP(TestTOTPSecret, v.Code, when, 0)
+      if !ok || step != n {
+        t.Fatalf("Code at %d refused with skew %d", v.Time, skew)
+      }
+    }
+
+    // Beyond the skew
+    when := at.Add(time.Duration((TOTPSkew + 1) * TOTPStep) * time.Second)
+    if _, ok := VerifyTOTP(TestTOTPSecret, v.Code, when, 0); ok {
+      t.Fatalf("Code at %d accepted beyond the skew", v.Time)
+    }
+
+    // Replayed
+    if _, ok := VerifyTOTP(TestTOTPSecret, v.Code, at, step); ok {
+      t.Fatalf("Code at %d accepted twice", v.Time)
+    }
+  }
+
+  // Wrong code, or malformed secret
+  if _, ok := VerifyTOTP(TestTOTPSecret, "000000", time.Unix(59, 0), 0); ok {
+    t.Fatalf("Wrong code accepted")
+  }
+  if _, ok := VerifyTOTP("not base32!", "287082", time.Unix(59, 0), 0); ok {
+    t.Fatalf("Malformed secret accepted")
+  }
+}
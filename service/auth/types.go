@@ -2,15 +2,16 @@ package auth
 
 import (
   "crypto/rand"
+  "maps"
   "math"
   "sync"
   "time"
-  "fmt"
 )
 
 const (
   MinSessionPeriod = 1 * time.Second
   MaxSessionPeriod = 1 * time.Hour
+  SessionLifetime  = 24 * time.Hour
 )
 
 
@@ -39,12 +40,48 @@ func (s *SyncMap[T,U]) Put (t T, u U) {
   s.m[t] = u
 }
 
+// Update replaces the value of t with that returned by f, which is given the
+// current value (and whether there is one). It returns the new value
+func (s *SyncMap[T,U]) Update (t T, f func (U, bool) U) U {
+  s.mutex.Lock()
+  defer s.mutex.Unlock()
+  u, ok := s.m[t]
+  s.m[t] = f(u, ok)
+  return s.m[t]
+}
+
 func (s *SyncMap[T,U]) Delete (t T) {
   s.mutex.Lock()
   defer s.mutex.Unlock()
   delete(s.m, t)
 }
 
+// Take removes t, returning the value it had (and whether it had one)
+func (s *SyncMap[T,U]) Take (t T) (U, bool) {
+  s.mutex.Lock()
+  defer s.mutex.Unlock()
+  u, ok := s.m[t]
+  delete(s.m, t)
+  return u, ok
+}
+
+func (s *SyncMap[T,U]) DeleteFunc (f func (T, U) bool) {
+  s.mutex.Lock()
+  defer s.mutex.Unlock()
+  for t, u := range s.m {
+    if f(t, u) {
+      delete(s.m, t)
+    }
+  }
+}
+
+// Copy returns a copy of the underlying map
+func (s *SyncMap[T,U]) Copy () map[T]U {
+  s.mutex.Lock()
+  defer s.mutex.Unlock()
+  return maps.Clone(s.m)
+}
+
 func NewSyncMap [T comparable, U any] () SyncMap[T,U] {
   return SyncMap[T,U] {
     m: make(map[T]U),
@@ -71,38 +108,30 @@ type Penalty struct {
   Count     int
 }
 
-// PenaltyFunc: Applies penalty function and returns the duration in seconds
-func penaltyFunc(i, base, factor, retry int) int {
-  return base * int(math.Pow(float64(factor), float64(i - retry)))
+// penaltyFunc: Applies the piecewise exponential penalty function to the
+// count of failures x, and returns the duration in seconds:
+// f(x) = | x <= retry : 0,
+//        | x > retry  : min(base * factor^(x - retry - 1), limit)
+func penaltyFunc (x, base, factor, retry, limit int) int {
+  if x <= retry {
+    return 0
+  }
+  return int(math.Min(float64(base) *
+    math.Pow(float64(factor), float64(x - retry - 1)), float64(limit)))
 }
 
-// Refresh: Applies the piecewise exponential penalty function:
-// f(x) = | x < retry : 0,
-//        | x >= retry : base * factor^(x - retry)
-func (p *Penalty) Refresh (c *Config) Penalty {
-  duration, increment := 0, 0
-  if p.Count >= c.Retry {
-    duration = penaltyFunc(p.Count, c.Base, c.Factor, c.Retry)
-  }
-  if duration < c.Limit {
-    increment = 1
-  }
-  fmt.Printf("Duration: %v\n", duration)
-  return Penalty {
-    Deadline: time.Now().UTC().Add(time.Duration(duration) * time.Second),
-    Count:    p.Count + increment,
-  }
+// PenaltyDuration: Returns the penalty following the given count of failures
+func PenaltyDuration (c *Config, count int) time.Duration {
+  return time.Duration(penaltyFunc(count, c.Base, c.Factor, c.Retry,
+    c.Limit)) * time.Second
 }
 
-// NewPenalty: Returns new penalty instantiated with hardcoded start duration
-func NewPenalty (c *Config) Penalty {
-  duration := 0
-  if c.Retry == 0 {
-    duration = penaltyFunc(0, c.Base, c.Factor, c.Retry)
-  }
+// Failed: Returns the penalty after one more failure
+func (p *Penalty) Failed (c *Config) Penalty {
   return Penalty {
-    Deadline: time.Now().UTC().Add(time.Duration(duration) * time.Second),
-    Count:    0,
+    Deadline: maxTime(p.Deadline, time.Now().UTC().Add(PenaltyDuration(c,
+      p.Count + 1))),
+    Count:    p.Count + 1,
   }
 }
 
@@ -114,10 +143,13 @@ func NewPenalty (c *Config) Penalty {
 \*/
 
 
+// Session: Expires when idle for the period, or at the deadline at the latest
 type Session struct {
   Expiration time.Time
+  Deadline   time.Time
   Period     time.Duration
   IP         string
+  Agent      string
   Secret     Hash
 }
 
@@ -127,18 +159,22 @@ func (s *Session) Expired () bool {
   return t.After(s.Expiration)
 }
 
-// Renew: Returns a new session with expiration: current time + duration
+// Renew: Returns a new session with expiration: current time + duration,
+// but no later than the deadline
 func (s *Session) Renew () Session {
   return Session {
-    Expiration: time.Now().UTC().Add(s.Period),
+    Expiration: minTime(time.Now().UTC().Add(s.Period), s.Deadline),
+    Deadline:   s.Deadline,
     Period:     s.Period,
     IP:         s.IP,
+    Agent:      s.Agent,
     Secret:     s.Secret,
   }
 }
 
-// NewSession: Returns new session for given IP and duration
-func NewSession (ip string, period time.Duration) (Session, error) {
+// NewSession: Returns new session for given client, idle duration, and
+// lifetime
+func NewSession (c Client, period, lifetime time.Duration) (Session, error) {
   var (
     b      []byte = make([]byte, HashSize)
     secret Hash   = Hash{}
@@ -151,63 +187,29 @@ func NewSession (ip string, period time.Duration) (Session, error) {
   // Copy random buffer into hash
   copy(secret[:], b[:HashSize])
 
+  now := time.Now().UTC()
   return Session {
-    Expiration: time.Now().UTC().Add(period),
+    Expiration: minTime(now.Add(period), now.Add(lifetime)),
+    Deadline:   now.Add(lifetime),
     Period:     period,
-    IP:         ip,
+    IP:         c.IP,
+    Agent:      Fingerprint(c.Agent),
     Secret:     secret,
   }, nil
 }
 
+// maxTime: Returns the later of two times
+func maxTime (a, b time.Time) time.Time {
+  if a.After(b) {
+    return a
+  }
+  return b
+}
 
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
+// minTime: Returns the earlier of two times
+func minTime (a, b time.Time) time.Time {
+  if a.Before(b) {
+    return a
+  }
+  return b
+}
@@ -0,0 +1,229 @@
+// Package certificate serves the TLS certificate of the server. The
+// certificate and key are read from disk, and reloaded whenever either file
+// changes (or when asked to), so renewed certificates are picked up without
+// restarting the process. Handshakes in progress keep the certificate they
+// started with
+
+package certificate
+
+import (
+  "crypto/tls"
+  "fmt"
+  "micrified.com/internal/reload"
+  "os"
+  "sync"
+  "time"
+)
+
+const (
+  DefaultInterval = 60 * time.Second
+)
+
+const (
+  CiphersDefault = "default"
+  CiphersStrict  = "strict"
+)
+
+// strictCiphers: Forward secret AEAD suites only. Suites of TLS 1.3 are not
+// configurable, and are all acceptable
+var strictCiphers = []uint16 {
+  tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+  tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+  tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+  tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+  tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+  tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+}
+
+// versions: The minimum versions that may be configured
+var versions = map[string]uint16 {
+  "":    tls.VersionTLS12,
+  "1.2": tls.VersionTLS12,
+  "1.3": tls.VersionTLS13,
+}
+
+
+/*\
+ *******************************************************************************
+ *                             Definition: Service                             *
+ *******************************************************************************
+\*/
+
+
+// Config: TLS is enabled if a certificate is given. Redirect names a port on
+// which plain HTTP requests are redirected to HTTPS, and HSTS the max-age in
+// seconds of the Strict-Transport-Security header (none if zero)
+type Config struct {
+  Cert       string
+  Key        string
+  MinVersion string
+  Ciphers    string
+  Redirect   string
+  HSTS       int
+  Interval   int
+}
+
+type Service struct {
+  config      Config
+  interval    time.Duration
+  minVersion  uint16
+  ciphers     []uint16
+  modified    [2]time.Time
+  certificate *tls.Certificate
+  stop        chan struct{}
+  mutex       sync.RWMutex
+}
+
+// NewService returns a service serving the configured certificate, whose
+// files are checked for changes every Interval seconds once started. Without
+// a certificate, TLS is disabled
+func NewService (c Config) (Service, error) {
+  var (
+    interval    time.Duration    = DefaultInterval
+    ciphers     []uint16         = nil
+    modified    [2]time.Time     = [2]time.Time{}
+    certificate *tls.Certificate = nil
+    err         error            = nil
+  )
+  minVersion, ok := versions[c.MinVersion]
+  if !ok {
+    return Service{}, fmt.Errorf("Unknown minimum TLS version %q", c.MinVersion)
+  }
+  switch c.Ciphers {
+  case "", CiphersDefault:
+  case CiphersStrict:
+    ciphers = strictCiphers
+  default:
+    return Service{}, fmt.Errorf("Unknown cipher policy %q", c.Ciphers)
+  }
+  if c.Interval > 0 {
+    interval = time.Duration(c.Interval) * time.Second
+  }
+  if ("" == c.Cert) != ("" == c.Key) {
+    return Service{}, fmt.Errorf("Unmet condition: Both or neither of Cert, Key")
+  }
+  if "" != c.Cert {
+    if certificate, modified, err = load(c.Cert, c.Key); nil != err {
+      return Service{}, err
+    }
+  }
+  return Service {
+    config:      c,
+    interval:    interval,
+    minVersion:  minVersion,
+    ciphers:     ciphers,
+    modified:    modified,
+    certificate: certificate,
+    stop:        make(chan struct{}),
+    mutex:       sync.RWMutex{},
+  }, nil
+}
+
+// Enabled returns true if a certificate is configured
+func (s *Service) Enabled () bool {
+  return "" != s.config.Cert
+}
+
+// Redirect returns the port on which to redirect plain HTTP, if any
+func (s *Service) Redirect () string {
+  return s.config.Redirect
+}
+
+// HSTS returns the value of the Strict-Transport-Security header, or the
+// empty string if none is to be sent
+func (s *Service) HSTS () string {
+  if !s.Enabled() || s.config.HSTS < 1 {
+    return ""
+  }
+  return fmt.Sprintf("max-age=%d", s.config.HSTS)
+}
+
+// TLSConfig returns the configuration for the TLS listener. The certificate
+// is looked up on every handshake, so reloading it takes effect at once
+func (s *Service) TLSConfig () *tls.Config {
+  return &tls.Config {
+    MinVersion:     s.minVersion,
+    CipherSuites:   s.ciphers,
+    GetCertificate: s.getCertificate,
+  }
+}
+
+func (s *Service) getCertificate (*tls.ClientHelloInfo) (*tls.Certificate, error) {
+  s.mutex.RLock()
+  defer s.mutex.RUnlock()
+  return s.certificate, nil
+}
+
+// modTimes returns the time the certificate and key files were last modified
+func modTimes (cert, key string) ([2]time.Time, error) {
+  var times [2]time.Time
+  for i, file := range []string{ cert, key } {
+    info, err := os.Stat(file)
+    if nil != err {
+      return times, fmt.Errorf("Couldn't read certificate %s: %w", file, err)
+    }
+    times[i] = info.ModTime()
+  }
+  return times, nil
+}
+
+// load reads the certificate and key, returning the certificate and the
+// times the files were last modified
+func load (cert, key string) (*tls.Certificate, [2]time.Time, error) {
+  modified, err := modTimes(cert, key)
+  if nil != err {
+    return nil, modified, err
+  }
+  certificate, err := tls.LoadX509KeyPair(cert, key)
+  if nil != err {
+    return nil, modified, fmt.Errorf("Bad certificate %s: %w", cert, err)
+  }
+  return &certificate, modified, nil
+}
+
+// Load reads the certificate and key. The certificate in use is only
+// replaced if they form a valid pair
+func (s *Service) Load () error {
+  certificate, modified, err := load(s.config.Cert, s.config.Key)
+  if nil != err {
+    return err
+  }
+  s.mutex.Lock()
+  defer s.mutex.Unlock()
+  s.certificate, s.modified = certificate, modified
+  return nil
+}
+
+// Reload loads the certificate and key if either changed since last loaded.
+// It returns true if the certificate was replaced
+func (s *Service) Reload () (bool, error) {
+  modified, err := modTimes(s.config.Cert, s.config.Key)
+  if nil != err {
+    return false, err
+  }
+  s.mutex.RLock()
+  unchanged := modified[0].Equal(s.modified[0]) &&
+    modified[1].Equal(s.modified[1])
+  s.mutex.RUnlock()
+  if unchanged {
+    return false, nil
+  }
+  if err = s.Load(); nil != err {
+    return false, err
+  }
+  return true, nil
+}
+
+// Start watches the certificate and key for changes until stopped. Errors
+// are logged, and the certificate last loaded remains in use
+func (s *Service) Start () {
+  if !s.Enabled() {
+    return
+  }
+  reload.Watch("Certificate", s.config.Cert, s.interval, s.stop, s.Reload)
+}
+
+// Stop ends watching the certificate and key
+func (s *Service) Stop () {
+  close(s.stop)
+}
@@ -40,7 +40,6 @@ type Config struct {
 
 type Service struct {
   Database string
-  Context  context.Context
   DB       *sql.DB
 }
 
@@ -58,7 +57,6 @@ func NewService (c Config) (Service, error) {
   }
   return Service {
     Database: c.Database,
-    Context:  context.Background(),
     DB:       db,
   }, nil
 }
@@ -72,11 +70,13 @@ func (d *Service) Stop () error {
 
 type TFunc func (sql.Result, *sql.Tx) (sql.Result, error)
 
-func (d *Service) Transaction (fs ...TFunc) (sql.Result, error) {
+// Transaction runs the functions in sequence within one transaction, which is
+// rolled back if any fails, or if the context ends first
+func (d *Service) Transaction (x context.Context, fs ...TFunc) (sql.Result, error) {
   var r sql.Result = nil
 
   // Begin transaction
-  t, err := d.DB.BeginTx(d.Context, nil)
+  t, err := d.DB.BeginTx(x, nil)
   if nil != err {
     return nil, err
   }
@@ -100,11 +100,13 @@ func (d *Service) Transaction (fs ...TFunc) (sql.Result, error) {
 
 type CFunc func (sql.Result, *sql.Conn) (sql.Result, error)
 
-func (d *Service) Connection (fs ...CFunc) (sql.Result, error) {
+// Connection runs the functions in sequence on one connection, held for no
+// longer than the context
+func (d *Service) Connection (x context.Context, fs ...CFunc) (sql.Result, error) {
   var r sql.Result = nil
 
   // Begin connection
-  c, err := d.DB.Conn(d.Context)
+  c, err := d.DB.Conn(x)
   if nil != err {
     return nil, err
   }
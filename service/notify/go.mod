@@ -0,0 +1,3 @@
+module micrified.com/service/notify
+
+go 1.22.3
@@ -0,0 +1,113 @@
+// Package notify delivers messages (e.g. password reset links) to users.
+// Delivery is pluggable: the service wraps any Notifier. For local use, a
+// notifier writing messages to a file (or the standard output) is provided
+
+package notify
+
+import (
+  "fmt"
+  "io"
+  "os"
+  "sync"
+  "time"
+)
+
+const (
+  KindLog = "log"
+)
+
+
+/*\
+ *******************************************************************************
+ *                            Definition: Notifier                             *
+ *******************************************************************************
+\*/
+
+
+// Message: A notification addressed to a recipient
+type Message struct {
+  To      string
+  Subject string
+  Body    string
+}
+
+// Notifier: Delivers messages. Implementations must be thread safe
+type Notifier interface {
+  Notify(Message) error
+}
+
+// LogNotifier: Writes messages to a log instead of delivering them
+type LogNotifier struct {
+  w     io.Writer
+  mutex sync.Mutex
+}
+
+// NewLogNotifier returns a notifier appending to the named file, or writing
+// to the standard output if no file is named
+func NewLogNotifier (file string) (*LogNotifier, error) {
+  if "" == file {
+    return &LogNotifier { w: os.Stdout }, nil
+  }
+  f, err := os.OpenFile(file, os.O_APPEND | os.O_CREATE | os.O_WRONLY, 0600)
+  if nil != err {
+    return nil, fmt.Errorf("Couldn't open notification log %s: %w", file, err)
+  }
+  return &LogNotifier { w: f }, nil
+}
+
+// Close closes the log, unless it is the standard output
+func (n *LogNotifier) Close () error {
+  n.mutex.Lock()
+  defer n.mutex.Unlock()
+  if f, ok := n.w.(*os.File); ok && os.Stdout != f {
+    return f.Close()
+  }
+  return nil
+}
+
+func (n *LogNotifier) Notify (m Message) error {
+  n.mutex.Lock()
+  defer n.mutex.Unlock()
+  _, err := fmt.Fprintf(n.w, "Date: %s\nTo: %s\nSubject: %s\n\n%s\n\n",
+    time.Now().UTC().Format(time.RFC1123Z), m.To, m.Subject, m.Body)
+  return err
+}
+
+
+/*\
+ *******************************************************************************
+ *                             Definition: Service                             *
+ *******************************************************************************
+\*/
+
+
+type Config struct {
+  Kind string
+  File string
+}
+
+type Service struct {
+  Notifier
+}
+
+// NewService returns a service delivering messages with the configured kind
+// of notifier. The kind defaults to the log notifier
+func NewService (c Config) (Service, error) {
+  switch c.Kind {
+  case "", KindLog:
+    n, err := NewLogNotifier(c.File)
+    if nil != err {
+      return Service{}, err
+    }
+    return Service { Notifier: n }, nil
+  }
+  return Service{}, fmt.Errorf("Unknown notifier kind %q", c.Kind)
+}
+
+// Stop releases the notifier, if it holds anything to release
+func (s *Service) Stop () error {
+  if c, ok := s.Notifier.(io.Closer); ok {
+    return c.Close()
+  }
+  return nil
+}
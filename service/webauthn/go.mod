@@ -0,0 +1,16 @@
+module micrified.com/service/webauthn
+
+replace micrified.com/service/auth => ../auth
+
+go 1.22.3
+
+require (
+	github.com/fxamacker/cbor/v2 v2.7.0
+	micrified.com/service/auth v0.0.0-00010101000000-000000000000
+)
+
+require (
+	github.com/x448/float16 v0.8.4 // indirect
+	golang.org/x/crypto v0.23.0 // indirect
+	golang.org/x/sys v0.20.0 // indirect
+)
@@ -0,0 +1,552 @@
+// Package webauthn implements the relying party side of the WebAuthn
+// registration and authentication ceremonies (https://www.w3.org/TR/webauthn)
+// for passwordless login with passkeys.
+//
+// Only the "none" attestation format is accepted: authenticators are trusted
+// on first use, and no attestation chain is verified. Credentials with ES256
+// (ECDSA P-256) or EdDSA (Ed25519) keys are supported. Attestation objects
+// and public keys are CBOR encoded, and are decoded with the package at
+// https://pkg.go.dev/github.com/fxamacker/cbor/v2
+
+package webauthn
+
+import (
+  "bytes"
+  "crypto/ecdh"
+  "crypto/ecdsa"
+  "crypto/ed25519"
+  "crypto/elliptic"
+  "crypto/rand"
+  "crypto/sha256"
+  "encoding/base64"
+  "encoding/binary"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "github.com/fxamacker/cbor/v2"
+  "math/big"
+  "micrified.com/service/auth"
+  "slices"
+  "strings"
+  "sync"
+  "time"
+)
+
+const (
+  CeremonyPeriod  = 5 * time.Minute
+  CeremoniesPerIP = 8
+  MaxCeremonies   = 10000
+  ChallengeSize   = 32
+  CredentialType  = "public-key"
+  TypeCreate      = "webauthn.create"
+  TypeGet         = "webauthn.get"
+  AttestationNone = "none"
+)
+
+const (
+  AlgorithmES256 = -7
+  AlgorithmEdDSA = -8
+)
+
+const (
+  flagUserPresent = 0x01
+  flagAttested    = 0x40
+  authDataSize    = 37
+)
+
+const (
+  coseKeyTypeOKP = 1
+  coseKeyTypeEC2 = 2
+  coseCurveP256  = 1
+  coseCurveEd25519 = 6
+)
+
+
+/*\
+ *******************************************************************************
+ *                           Definitions: Encoding                             *
+ *******************************************************************************
+\*/
+
+
+// Bytes: Binary data, encoded in JSON as unpadded base64url
+type Bytes []byte
+
+func (b Bytes) MarshalJSON () ([]byte, error) {
+  return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *Bytes) UnmarshalJSON (data []byte) error {
+  var s string
+  if err := json.Unmarshal(data, &s); nil != err {
+    return err
+  }
+  decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+  if nil != err {
+    return err
+  }
+  *b = decoded
+  return nil
+}
+
+
+/*\
+ *******************************************************************************
+ *                          Definitions: Ceremony types                        *
+ *******************************************************************************
+\*/
+
+
+type RelyingParty struct {
+  ID   string `json:"id"`
+  Name string `json:"name"`
+}
+
+type UserEntity struct {
+  ID          Bytes  `json:"id"`
+  Name        string `json:"name"`
+  DisplayName string `json:"displayName"`
+}
+
+type Parameter struct {
+  Type      string `json:"type"`
+  Algorithm int    `json:"alg"`
+}
+
+type Descriptor struct {
+  Type string `json:"type"`
+  ID   Bytes  `json:"id"`
+}
+
+type Selection struct {
+  ResidentKey      string `json:"residentKey"`
+  UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions: Options for navigator.credentials.create
+type CreationOptions struct {
+  Challenge   Bytes        `json:"challenge"`
+  RP          RelyingParty `json:"rp"`
+  User        UserEntity   `json:"user"`
+  Parameters  []Parameter  `json:"pubKeyCredParams"`
+  Timeout     int64        `json:"timeout"`
+  Attestation string       `json:"attestation"`
+  Exclude     []Descriptor `json:"excludeCredentials"`
+  Selection   Selection    `json:"authenticatorSelection"`
+}
+
+// RequestOptions: Options for navigator.credentials.get
+type RequestOptions struct {
+  Challenge        Bytes        `json:"challenge"`
+  Timeout          int64        `json:"timeout"`
+  RPID             string       `json:"rpId"`
+  Allow            []Descriptor `json:"allowCredentials"`
+  UserVerification string       `json:"userVerification"`
+}
+
+type AttestationResponse struct {
+  ClientDataJSON    Bytes `json:"clientDataJSON"`
+  AttestationObject Bytes `json:"attestationObject"`
+}
+
+// RegistrationResponse: The credential returned by navigator.credentials.create
+type RegistrationResponse struct {
+  ID       string              `json:"id"`
+  RawID    Bytes               `json:"rawId"`
+  Type     string              `json:"type"`
+  Response AttestationResponse `json:"response"`
+}
+
+type AssertionResponse struct {
+  ClientDataJSON    Bytes `json:"clientDataJSON"`
+  AuthenticatorData Bytes `json:"authenticatorData"`
+  Signature         Bytes `json:"signature"`
+  UserHandle        Bytes `json:"userHandle"`
+}
+
+// AuthenticationResponse: The credential returned by navigator.credentials.get
+type AuthenticationResponse struct {
+  ID       string            `json:"id"`
+  RawID    Bytes             `json:"rawId"`
+  Type     string            `json:"type"`
+  Response AssertionResponse `json:"response"`
+}
+
+// Credential: A registered public key credential
+type Credential struct {
+  ID        []byte
+  PublicKey []byte
+  SignCount uint32
+}
+
+// Ceremony: A registration or authentication awaiting a response
+type Ceremony struct {
+  Username   string
+  IP         string
+  Type       string
+  Expiration time.Time
+}
+
+type clientData struct {
+  Type      string `json:"type"`
+  Challenge string `json:"challenge"`
+  Origin    string `json:"origin"`
+}
+
+type attestationObject struct {
+  Format    string          `cbor:"fmt"`
+  Statement cbor.RawMessage `cbor:"attStmt"`
+  AuthData  []byte          `cbor:"authData"`
+}
+
+type authenticatorData struct {
+  RPIDHash   []byte
+  Flags      byte
+  SignCount  uint32
+  Credential Credential
+}
+
+type coseKey struct {
+  Type      int    `cbor:"1,keyasint"`
+  Algorithm int    `cbor:"3,keyasint"`
+  Curve     int    `cbor:"-1,keyasint,omitempty"`
+  X         []byte `cbor:"-2,keyasint,omitempty"`
+  Y         []byte `cbor:"-3,keyasint,omitempty"`
+}
+
+
+/*\
+ *******************************************************************************
+ *                          Definitions: Verification                          *
+ *******************************************************************************
+\*/
+
+
+// parseAuthenticatorData: Decodes the authenticator data structure, including
+// the attested credential data if flagged as present
+func parseAuthenticatorData (b []byte) (authenticatorData, error) {
+  var z authenticatorData
+
+  if len(b) < authDataSize {
+    return z, fmt.Errorf("Authenticator data too short")
+  }
+  z.RPIDHash, z.Flags = b[:32], b[32]
+  z.SignCount = binary.BigEndian.Uint32(b[33:37])
+
+  // Case: No attested credential data
+  if 0 == z.Flags & flagAttested {
+    return z, nil
+  }
+
+  // AAGUID (16), credential ID length (2), credential ID, COSE public key
+  rest := b[authDataSize:]
+  if len(rest) < 18 {
+    return z, fmt.Errorf("Attested credential data too short")
+  }
+  n := int(binary.BigEndian.Uint16(rest[16:18]))
+  if len(rest) < 18 + n {
+    return z, fmt.Errorf("Credential ID too short")
+  }
+  z.Credential.ID = rest[18:18 + n]
+
+  var key cbor.RawMessage
+  if _, err := cbor.UnmarshalFirst(rest[18 + n:], &key); nil != err {
+    return z, fmt.Errorf("Malformed credential public key: %w", err)
+  }
+  z.Credential.PublicKey = key
+  z.Credential.SignCount = z.SignCount
+  return z, nil
+}
+
+// parseKey: Decodes and validates a COSE public key
+func parseKey (b []byte) (coseKey, error) {
+  var k coseKey
+  if err := cbor.Unmarshal(b, &k); nil != err {
+    return k, fmt.Errorf("Malformed public key: %w", err)
+  }
+  switch {
+  case AlgorithmES256 == k.Algorithm && coseKeyTypeEC2 == k.Type &&
+    coseCurveP256 == k.Curve && 32 == len(k.X) && 32 == len(k.Y):
+    point := append([]byte{4}, append(slices.Clone(k.X), k.Y...)...)
+    if _, err := ecdh.P256().NewPublicKey(point); nil != err {
+      return k, fmt.Errorf("Invalid P-256 public key: %w", err)
+    }
+  case AlgorithmEdDSA == k.Algorithm && coseKeyTypeOKP == k.Type &&
+    coseCurveEd25519 == k.Curve && ed25519.PublicKeySize == len(k.X):
+  default:
+    return k, fmt.Errorf("Unsupported public key (algorithm %d)", k.Algorithm)
+  }
+  return k, nil
+}
+
+// verify: Checks the signature over the message
+func (k *coseKey) verify (message, signature []byte) bool {
+  switch k.Algorithm {
+  case AlgorithmES256:
+    digest := sha256.Sum256(message)
+    key := ecdsa.PublicKey {
+      Curve: elliptic.P256(),
+      X:     new(big.Int).SetBytes(k.X),
+      Y:     new(big.Int).SetBytes(k.Y),
+    }
+    return ecdsa.VerifyASN1(&key, digest[:], signature)
+  case AlgorithmEdDSA:
+    return ed25519.Verify(ed25519.PublicKey(k.X), message, signature)
+  }
+  return false
+}
+
+
+/*\
+ *******************************************************************************
+ *                             Definition: Service                             *
+ *******************************************************************************
+\*/
+
+
+// Config: The relying party identity. The RPID is the effective domain (e.g.
+// "micrified.com"), and Origins lists the permitted origins of the client
+// (e.g. "https://micrified.com"). Passkeys are disabled if RPID is empty
+type Config struct {
+  RPID    string
+  RPName  string
+  Origins []string
+}
+
+// ErrCeremonies: Too many ceremonies are outstanding, for the IP or overall
+var ErrCeremonies = errors.New("Too many outstanding ceremonies")
+
+type Service struct {
+  config     Config
+  ceremonies auth.SyncMap[string, Ceremony]
+  mutex      sync.Mutex
+}
+
+func NewService (c Config) (Service, error) {
+  if "" != c.RPID && 0 == len(c.Origins) {
+    return Service{}, fmt.Errorf("Unmet condition: len(Origins) >= 1")
+  }
+  return Service {
+    config:     c,
+    ceremonies: auth.NewSyncMap[string, Ceremony](),
+    mutex:      sync.Mutex{},
+  }, nil
+}
+
+// Enabled returns true if a relying party is configured
+func (s *Service) Enabled () bool {
+  return "" != s.config.RPID
+}
+
+// begin: Registers a new ceremony, returning its challenge. Expired
+// ceremonies are pruned first. Ceremonies are unauthenticated, so their
+// number is capped per IP, and overall (ErrCeremonies)
+func (s *Service) begin (ip, username, kind string) ([]byte, error) {
+  var (
+    now     time.Time = time.Now().UTC()
+    pending int       = 0
+    total   int       = 0
+  )
+
+  // Secure mutual exclusion (the count must hold until the ceremony is added)
+  s.mutex.Lock()
+  defer s.mutex.Unlock()
+
+  s.ceremonies.DeleteFunc(func (_ string, z Ceremony) bool {
+    if now.After(z.Expiration) {
+      return true
+    }
+    if ip == z.IP {
+      pending++
+    }
+    total++
+    return false
+  })
+  if pending >= CeremoniesPerIP || total >= MaxCeremonies {
+    return nil, ErrCeremonies
+  }
+
+  b := make([]byte, ChallengeSize)
+  if _, err := rand.Read(b); nil != err {
+    return nil, err
+  }
+  s.ceremonies.Put(base64.RawURLEncoding.EncodeToString(b), Ceremony {
+    Username:   username,
+    IP:         ip,
+    Type:       kind,
+    Expiration: now.Add(CeremonyPeriod),
+  })
+  return b, nil
+}
+
+// descriptors: Returns descriptors for the given credential IDs
+func descriptors (ids [][]byte) []Descriptor {
+  d := make([]Descriptor, len(ids))
+  for i, id := range ids {
+    d[i] = Descriptor { Type: CredentialType, ID: id }
+  }
+  return d
+}
+
+// BeginRegistration starts a registration ceremony for the user, on behalf
+// of the client IP. The handle is an opaque, stable identifier for the user.
+// Credentials already held by the user are excluded
+func (s *Service) BeginRegistration (ip, username string, handle []byte,
+  exclude [][]byte) (CreationOptions, error) {
+  challenge, err := s.begin(ip, username, TypeCreate)
+  if nil != err {
+    return CreationOptions{}, err
+  }
+  return CreationOptions {
+    Challenge: challenge,
+    RP:        RelyingParty { ID: s.config.RPID, Name: s.config.RPName },
+    User:      UserEntity { ID: handle, Name: username, DisplayName: username },
+    Parameters: []Parameter {
+      { Type: CredentialType, Algorithm: AlgorithmES256 },
+      { Type: CredentialType, Algorithm: AlgorithmEdDSA },
+    },
+    Timeout:     CeremonyPeriod.Milliseconds(),
+    Attestation: AttestationNone,
+    Exclude:     descriptors(exclude),
+    Selection:   Selection {
+      ResidentKey:      "preferred",
+      UserVerification: "preferred",
+    },
+  }, nil
+}
+
+// BeginLogin starts an authentication ceremony for the user, on behalf of
+// the client IP, restricted to the given credential IDs
+func (s *Service) BeginLogin (ip, username string,
+  allow [][]byte) (RequestOptions, error) {
+  challenge, err := s.begin(ip, username, TypeGet)
+  if nil != err {
+    return RequestOptions{}, err
+  }
+  return RequestOptions {
+    Challenge:        challenge,
+    Timeout:          CeremonyPeriod.Milliseconds(),
+    RPID:             s.config.RPID,
+    Allow:            descriptors(allow),
+    UserVerification: "preferred",
+  }, nil
+}
+
+// Redeem checks the client data of a response, and returns the ceremony it
+// answers. A ceremony can only be redeemed once
+func (s *Service) Redeem (clientDataJSON []byte, kind string) (Ceremony, error) {
+  var c clientData
+
+  if err := json.Unmarshal(clientDataJSON, &c); nil != err {
+    return Ceremony{}, fmt.Errorf("Malformed client data: %w", err)
+  }
+  if kind != c.Type {
+    return Ceremony{}, fmt.Errorf("Unexpected client data type %q", c.Type)
+  }
+  if !slices.Contains(s.config.Origins, c.Origin) {
+    return Ceremony{}, fmt.Errorf("Unexpected origin %q", c.Origin)
+  }
+
+  key := strings.TrimRight(c.Challenge, "=")
+  z, ok := s.ceremonies.Take(key)
+  if !ok {
+    return Ceremony{}, fmt.Errorf("No such ceremony")
+  }
+
+  if kind != z.Type {
+    return Ceremony{}, fmt.Errorf("Ceremony type mismatch")
+  }
+  if time.Now().UTC().After(z.Expiration) {
+    return Ceremony{}, fmt.Errorf("Ceremony expired")
+  }
+  return z, nil
+}
+
+// checkAuthenticatorData: Verifies the relying party and user presence
+func (s *Service) checkAuthenticatorData (z *authenticatorData) error {
+  rpIDHash := sha256.Sum256([]byte(s.config.RPID))
+  if !bytes.Equal(rpIDHash[:], z.RPIDHash) {
+    return fmt.Errorf("Relying party mismatch")
+  }
+  if 0 == z.Flags & flagUserPresent {
+    return fmt.Errorf("User not present")
+  }
+  return nil
+}
+
+// FinishRegistration verifies the response to a registration ceremony, and
+// returns the username of the ceremony and the new credential
+func (s *Service) FinishRegistration (r *RegistrationResponse) (string, Credential, error) {
+  var object attestationObject
+
+  z, err := s.Redeem(r.Response.ClientDataJSON, TypeCreate)
+  if nil != err {
+    return "", Credential{}, err
+  }
+
+  // Decode attestation
+  if err = cbor.Unmarshal(r.Response.AttestationObject, &object); nil != err {
+    return "", Credential{}, fmt.Errorf("Malformed attestation: %w", err)
+  }
+  if AttestationNone != object.Format {
+    return "", Credential{}, fmt.Errorf("Unsupported attestation format %q",
+      object.Format)
+  }
+
+  // Verify authenticator data and credential
+  data, err := parseAuthenticatorData(object.AuthData)
+  if nil != err {
+    return "", Credential{}, err
+  }
+  if err = s.checkAuthenticatorData(&data); nil != err {
+    return "", Credential{}, err
+  }
+  if 0 == data.Flags & flagAttested {
+    return "", Credential{}, fmt.Errorf("No attested credential")
+  }
+  if !bytes.Equal(r.RawID, data.Credential.ID) {
+    return "", Credential{}, fmt.Errorf("Credential ID mismatch")
+  }
+  if _, err = parseKey(data.Credential.PublicKey); nil != err {
+    return "", Credential{}, err
+  }
+
+  return z.Username, data.Credential, nil
+}
+
+// VerifyAssertion verifies the response to an authentication ceremony (see
+// Redeem) against the stored credential. The credential is returned with its
+// updated signature counter. A counter that fails to increase indicates a
+// cloned authenticator, and is rejected
+func (s *Service) VerifyAssertion (r *AuthenticationResponse, c Credential) (Credential, error) {
+  if !bytes.Equal(r.RawID, c.ID) {
+    return c, fmt.Errorf("Credential ID mismatch")
+  }
+
+  data, err := parseAuthenticatorData(r.Response.AuthenticatorData)
+  if nil != err {
+    return c, err
+  }
+  if err = s.checkAuthenticatorData(&data); nil != err {
+    return c, err
+  }
+
+  // Signature is over authenticatorData || SHA-256(clientDataJSON)
+  key, err := parseKey(c.PublicKey)
+  if nil != err {
+    return c, err
+  }
+  digest := sha256.Sum256(r.Response.ClientDataJSON)
+  message := append(slices.Clone([]byte(r.Response.AuthenticatorData)),
+    digest[:]...)
+  if !key.verify(message, r.Response.Signature) {
+    return c, fmt.Errorf("Signature mismatch")
+  }
+
+  // Counters are optional (always zero) for some authenticators
+  if (0 != data.SignCount || 0 != c.SignCount) && data.SignCount <= c.SignCount {
+    return c, fmt.Errorf("Signature counter did not increase")
+  }
+  c.SignCount = data.SignCount
+  return c, nil
+}
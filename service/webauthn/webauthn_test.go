@@ -0,0 +1,292 @@
+package webauthn
+
+import (
+  "crypto/ecdsa"
+  "crypto/elliptic"
+  "crypto/rand"
+  "crypto/sha256"
+  "encoding/base64"
+  "encoding/binary"
+  "encoding/json"
+  "github.com/fxamacker/cbor/v2"
+  "testing"
+)
+
+const (
+  TestRPID     = "micrified.com"
+  TestOrigin   = "https://micrified.com"
+  TestUsername = "tester"
+  TestIP       = "192.0.2.1"
+)
+
+
+/*\
+ *******************************************************************************
+ *                           Software Authenticator                            *
+ *******************************************************************************
+\*/
+
+
+// authenticator is a software authenticator holding a single ES256 credential
+type authenticator struct {
+  id      []byte
+  key     *ecdsa.PrivateKey
+  counter uint32
+  rpID    string
+  origin  string
+}
+
+func newAuthenticator (t *testing.T) *authenticator {
+  key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+  if nil != err {
+    t.Fatalf("Key generation failed: %v", err)
+  }
+  id := make([]byte, 16)
+  rand.Read(id)
+  return &authenticator { id: id, key: key, rpID: TestRPID, origin: TestOrigin }
+}
+
+// clientData returns the client data JSON the browser would compose
+func (a *authenticator) clientData (t *testing.T, kind string, challenge []byte) []byte {
+  b, err := json.Marshal(clientData {
+    Type:      kind,
+    Challenge: base64.RawURLEncoding.EncodeToString(challenge),
+    Origin:    a.origin,
+  })
+  if nil != err {
+    t.Fatalf("Client data encoding failed: %v", err)
+  }
+  return b
+}
+
+// authenticatorData returns the authenticator data, optionally including the
+// attested credential data
+func (a *authenticator) authenticatorData (t *testing.T, attested bool) []byte {
+  var (
+    flags byte    = flagUserPresent
+    b     []byte  = []byte{}
+  )
+  rpIDHash := sha256.Sum256([]byte(a.rpID))
+  if attested {
+    flags |= flagAttested
+  }
+  b = append(b, rpIDHash[:]...)
+  b = append(b, flags)
+  b = binary.BigEndian.AppendUint32(b, a.counter)
+  if !attested {
+    return b
+  }
+
+  key, err := cbor.Marshal(coseKey {
+    Type:      coseKeyTypeEC2,
+    Algorithm: AlgorithmES256,
+    Curve:     coseCurveP256,
+    X:         a.key.X.FillBytes(make([]byte, 32)),
+    Y:         a.key.Y.FillBytes(make([]byte, 32)),
+  })
+  if nil != err {
+    t.Fatalf("Key encoding failed: %v", err)
+  }
+  b = append(b, make([]byte, 16)...)
+  b = binary.BigEndian.AppendUint16(b, uint16(len(a.id)))
+  b = append(b, a.id...)
+  return append(b, key...)
+}
+
+// create responds to registration options
+func (a *authenticator) create (t *testing.T, o *CreationOptions) *RegistrationResponse {
+  object, err := cbor.Marshal(map[string]any {
+    "fmt":      AttestationNone,
+    "attStmt":  map[string]any{},
+    "authData": a.authenticatorData(t, true),
+  })
+  if nil != err {
+    t.Fatalf("Attestation encoding failed: %v", err)
+  }
+  return &RegistrationResponse {
+    ID:    base64.RawURLEncoding.EncodeToString(a.id),
+    RawID: a.id,
+    Type:  CredentialType,
+    Response: AttestationResponse {
+      ClientDataJSON:    a.clientData(t, TypeCreate, o.Challenge),
+      AttestationObject: object,
+    },
+  }
+}
+
+// get responds to authentication options, signing the assertion
+func (a *authenticator) get (t *testing.T, o *RequestOptions) *AuthenticationResponse {
+  a.counter++
+  data, client := a.authenticatorData(t, false), a.clientData(t, TypeGet,
+    o.Challenge)
+  digest := sha256.Sum256(client)
+  message := sha256.Sum256(append(append([]byte{}, data...), digest[:]...))
+  signature, err := ecdsa.SignASN1(rand.Reader, a.key, message[:])
+  if nil != err {
+    t.Fatalf("Signing failed: %v", err)
+  }
+  return &AuthenticationResponse {
+    ID:    base64.RawURLEncoding.EncodeToString(a.id),
+    RawID: a.id,
+    Type:  CredentialType,
+    Response: AssertionResponse {
+      ClientDataJSON:    client,
+      AuthenticatorData: data,
+      Signature:         signature,
+    },
+  }
+}
+
+// newTestService returns a service for the test relying party
+func newTestService (t *testing.T) *Service {
+  s, err := NewService(Config {
+    RPID: TestRPID, RPName: "Test", Origins: []string{ TestOrigin },
+  })
+  if nil != err {
+    t.Fatalf("Service creation failed: %v", err)
+  }
+  return &s
+}
+
+// register completes a registration ceremony, returning the credential
+func register (t *testing.T, s *Service, a *authenticator) Credential {
+  options, err := s.BeginRegistration(TestIP, TestUsername, []byte{1}, nil)
+  if nil != err {
+    t.Fatalf("BeginRegistration failed: %v", err)
+  }
+  username, credential, err := s.FinishRegistration(a.create(t, &options))
+  if nil != err {
+    t.Fatalf("FinishRegistration failed: %v", err)
+  }
+  if TestUsername != username {
+    t.Fatalf("Registered to %q, expected %q", username, TestUsername)
+  }
+  return credential
+}
+
+// login completes an authentication ceremony, returning any error
+func login (t *testing.T, s *Service, a *authenticator, c Credential) (Credential, error) {
+  options, err := s.BeginLogin(TestIP, TestUsername, [][]byte{ c.ID })
+  if nil != err {
+    t.Fatalf("BeginLogin failed: %v", err)
+  }
+  response := a.get(t, &options)
+  z, err := s.Redeem(response.Response.ClientDataJSON, TypeGet)
+  if nil != err {
+    return c, err
+  }
+  if TestUsername != z.Username {
+    t.Fatalf("Ceremony for %q, expected %q", z.Username, TestUsername)
+  }
+  return s.VerifyAssertion(response, c)
+}
+
+
+/*\
+ *******************************************************************************
+ *                                    Tests                                    *
+ *******************************************************************************
+\*/
+
+
+// TestCeremonies registers a credential with a software authenticator, and
+// verifies that repeated logins succeed with an increasing counter
+func TestCeremonies (t *testing.T) {
+  s, a := newTestService(t), newAuthenticator(t)
+  credential := register(t, s, a)
+
+  for i := 0; i < 3; i++ {
+    updated, err := login(t, s, a, credential)
+    if nil != err {
+      t.Fatalf("Login %d failed: %v", i, err)
+    }
+    if updated.SignCount <= credential.SignCount {
+      t.Fatalf("Counter not updated (%d)", updated.SignCount)
+    }
+    credential = updated
+  }
+}
+
+// TestCeremonyReplay verifies that a response cannot be redeemed twice
+func TestCeremonyReplay (t *testing.T) {
+  s, a := newTestService(t), newAuthenticator(t)
+  options, err := s.BeginRegistration(TestIP, TestUsername, []byte{1}, nil)
+  if nil != err {
+    t.Fatalf("BeginRegistration failed: %v", err)
+  }
+  response := a.create(t, &options)
+  if _, _, err = s.FinishRegistration(response); nil != err {
+    t.Fatalf("FinishRegistration failed: %v", err)
+  }
+  if _, _, err = s.FinishRegistration(response); nil == err {
+    t.Fatalf("Replayed registration accepted")
+  }
+}
+
+// TestCeremonyRejections verifies that responses from the wrong origin, for
+// the wrong relying party, with a bad signature, or from a cloned
+// authenticator are rejected
+func TestCeremonyRejections (t *testing.T) {
+  s, a := newTestService(t), newAuthenticator(t)
+  credential := register(t, s, a)
+
+  // Wrong origin
+  a.origin = "https://example.com"
+  if _, err := login(t, s, a, credential); nil == err {
+    t.Fatalf("Foreign origin accepted")
+  }
+  a.origin = TestOrigin
+
+  // Wrong relying party
+  a.rpID = "example.com"
+  if _, err := login(t, s, a, credential); nil == err {
+    t.Fatalf("Foreign relying party accepted")
+  }
+  a.rpID = TestRPID
+
+  // Bad signature (key not matching the registered credential)
+  other := newAuthenticator(t)
+  other.id = a.id
+  if _, err := login(t, s, other, credential); nil == err {
+    t.Fatalf("Bad signature accepted")
+  }
+
+  // Cloned authenticator (counter does not increase)
+  credential, err := login(t, s, a, credential)
+  if nil != err {
+    t.Fatalf("Login failed: %v", err)
+  }
+  a.counter = credential.SignCount - 1
+  if _, err = login(t, s, a, credential); nil == err {
+    t.Fatalf("Stale counter accepted")
+  }
+}
+
+// TestCeremonyLimit verifies that outstanding ceremonies are capped per IP,
+// and that other IPs may still begin ceremonies
+func TestCeremonyLimit (t *testing.T) {
+  s := newTestService(t)
+  for i := 0; i < CeremoniesPerIP; i++ {
+    if _, err := s.BeginLogin(TestIP, TestUsername, nil); nil != err {
+      t.Fatalf("BeginLogin %d failed: %v", i, err)
+    }
+  }
+  if _, err := s.BeginLogin(TestIP, TestUsername, nil); ErrCeremonies != err {
+    t.Fatalf("Ceremony beyond the limit: %v", err)
+  }
+  if _, err := s.BeginLogin("192.0.2.2", TestUsername, nil); nil != err {
+    t.Fatalf("BeginLogin from another IP failed: %v", err)
+  }
+
+  // Expired ceremonies are pruned, and no longer count
+  for key, z := range s.ceremonies.Copy() {
+    z.Expiration = z.Expiration.Add(-2 * CeremonyPeriod)
+    s.ceremonies.Put(key, z)
+  }
+  if _, err := s.BeginLogin(TestIP, TestUsername, nil); nil != err {
+    t.Fatalf("BeginLogin after expiry failed: %v", err)
+  }
+  if n := len(s.ceremonies.Copy()); 1 != n {
+    t.Fatalf("%d ceremonies outstanding, expected 1", n)
+  }
+}